@@ -0,0 +1,143 @@
+package elf
+
+import (
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// ABIIntRegisters are the DWARF numbers of the integer registers assigned
+// in order by the Go register ABI on amd64: RAX, RBX, RCX, RDI, RSI, R8, R9,
+// R10 and R11.
+var ABIIntRegisters = []int{0, 3, 2, 5, 4, 8, 9, 10, 11}
+
+const (
+	abiFloatRegisters = 15
+	// dwarfXmm0 is the DWARF number of X0
+	dwarfXmm0 = 17
+)
+
+// abiAssigner assigns the arguments or the results of a function to the
+// registers, or to the stack if they don't fit, see https://go.dev/s/regabi.
+type abiAssigner struct {
+	ints, floats int
+	// stackOffset is the offset from rsp at the function entry
+	stackOffset int64
+	pieces      []Piece
+}
+
+func newABIAssigner() *abiAssigner {
+	// skip the return address
+	return &abiAssigner{stackOffset: 8}
+}
+
+// results resets the registers for the results, which are on the stack
+// after the arguments aligned to a pointer.
+func (a *abiAssigner) results() {
+	a.ints, a.floats = 0, 0
+	a.stackOffset = alignUp(a.stackOffset, 8)
+}
+
+func (a *abiAssigner) assign(typ godwarf.Type) (pieces []Piece) {
+	ints, floats := a.ints, a.floats
+	a.pieces = nil
+	if a.assignRegisters(typ) {
+		return a.pieces
+	}
+	a.ints, a.floats = ints, floats
+	a.stackOffset = alignUp(a.stackOffset, typeAlign(typ))
+	pieces = []Piece{{Size: typ.Size(), Register: -1, Offset: a.stackOffset}}
+	a.stackOffset += typ.Size()
+	return
+}
+
+func (a *abiAssigner) assignRegisters(typ godwarf.Type) bool {
+	switch typ := typ.(type) {
+	case *godwarf.TypedefType:
+		return a.assignRegisters(typ.Type)
+	case *godwarf.InterfaceType:
+		return a.assignRegisters(typ.Type)
+	case *godwarf.StringType:
+		return a.assignStruct(&typ.StructType)
+	case *godwarf.SliceType:
+		return a.assignStruct(&typ.StructType)
+	case *godwarf.StructType:
+		return a.assignStruct(typ)
+	case *godwarf.ArrayType:
+		switch typ.Count {
+		case 0:
+			return true
+		case 1:
+			return a.assignRegisters(typ.Type)
+		}
+		return false
+	case *godwarf.FloatType:
+		return a.assignFloat(typ.Size())
+	case *godwarf.ComplexType:
+		return a.assignFloat(typ.Size()/2) && a.assignFloat(typ.Size()/2)
+	case *godwarf.IntType, *godwarf.UintType, *godwarf.CharType, *godwarf.UcharType, *godwarf.BoolType,
+		*godwarf.PtrType, *godwarf.MapType, *godwarf.ChanType, *godwarf.FuncType:
+		if a.ints == len(ABIIntRegisters) {
+			return false
+		}
+		a.pieces = append(a.pieces, Piece{Size: typ.Size(), Register: ABIIntRegisters[a.ints]})
+		a.ints++
+		return true
+	}
+	return false
+}
+
+func (a *abiAssigner) assignFloat(size int64) bool {
+	if a.floats == abiFloatRegisters {
+		return false
+	}
+	a.pieces = append(a.pieces, Piece{Size: size, Register: dwarfXmm0 + a.floats})
+	a.floats++
+	return true
+}
+
+// assignStruct assigns the fields in order, and the padding after a field
+// is counted into its last piece so that the pieces cover the struct.
+func (a *abiAssigner) assignStruct(typ *godwarf.StructType) bool {
+	start := len(a.pieces)
+	for _, field := range typ.Field {
+		if field.Type.Size() == 0 {
+			continue
+		}
+		if len(a.pieces) > start {
+			end := int64(0)
+			for _, piece := range a.pieces[start:] {
+				end += piece.Size
+			}
+			a.pieces[len(a.pieces)-1].Size += field.ByteOffset - end
+		}
+		if !a.assignRegisters(field.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func typeAlign(typ godwarf.Type) int64 {
+	switch typ := typ.(type) {
+	case *godwarf.TypedefType:
+		return typeAlign(typ.Type)
+	case *godwarf.InterfaceType:
+		return typeAlign(typ.Type)
+	case *godwarf.ArrayType:
+		return typeAlign(typ.Type)
+	case *godwarf.ComplexType:
+		return typ.Size() / 2
+	case *godwarf.StringType, *godwarf.SliceType:
+		return 8
+	case *godwarf.StructType:
+		align := int64(1)
+		for _, field := range typ.Field {
+			align = max(align, typeAlign(field.Type))
+		}
+		return align
+	}
+	return min(max(typ.Size(), 1), 8)
+}
+
+func alignUp(offset, align int64) int64 {
+	return (offset + align - 1) / align * align
+}
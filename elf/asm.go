@@ -1,8 +1,12 @@
 package elf
 
 import (
+	"context"
+	"runtime"
+
 	"github.com/pkg/errors"
 	"golang.org/x/arch/x86/x86asm"
+	"golang.org/x/sync/semaphore"
 )
 
 func (e *ELF) FuncInstructions(name string) (insts []x86asm.Inst, addr, offset uint64, err error) {
@@ -14,6 +18,76 @@ func (e *ELF) FuncInstructions(name string) (insts []x86asm.Inst, addr, offset u
 }
 
 func (e *ELF) FuncRetOffsets(name string) (offsets []uint64, err error) {
+	disk := e.diskCache()
+	if offsets, ok := disk.RetOffsets[name]; ok {
+		return offsets, nil
+	}
+	if offsets, err = e.funcRetOffsets(name); err != nil {
+		return
+	}
+	disk.RetOffsets[name] = offsets
+	disk.dirty = true
+	return
+}
+
+// FuncsRetOffsets disassembles the functions missing in the cache by a
+// worker pool, and returns the errors of the functions in errs.
+func (e *ELF) FuncsRetOffsets(names []string) (offsets map[string][]uint64, errs map[string]error, err error) {
+	offsets = map[string][]uint64{}
+	errs = map[string]error{}
+	disk := e.diskCache()
+	todo := []string{}
+	for _, name := range names {
+		if o, ok := disk.RetOffsets[name]; ok {
+			offsets[name] = o
+		} else {
+			todo = append(todo, name)
+		}
+	}
+	if len(todo) == 0 {
+		return
+	}
+
+	// warm up the caches, which are only read by the workers
+	if _, err = e.funcRanges(); err != nil {
+		return
+	}
+	if _, _, err = e.Symbols(); err != nil {
+		return
+	}
+	if _, err = e.Text(); err != nil {
+		return
+	}
+
+	type result struct {
+		offsets []uint64
+		err     error
+	}
+	results := make([]result, len(todo))
+	workers := int64(runtime.NumCPU())
+	sem := semaphore.NewWeighted(workers)
+	for i, name := range todo {
+		sem.Acquire(context.Background(), 1)
+		go func(i int, name string) {
+			defer sem.Release(1)
+			results[i].offsets, results[i].err = e.funcRetOffsets(name)
+		}(i, name)
+	}
+	sem.Acquire(context.Background(), workers)
+
+	for i, name := range todo {
+		if results[i].err != nil {
+			errs[name] = results[i].err
+			continue
+		}
+		offsets[name] = results[i].offsets
+		disk.RetOffsets[name] = results[i].offsets
+		disk.dirty = true
+	}
+	return
+}
+
+func (e *ELF) funcRetOffsets(name string) (offsets []uint64, err error) {
 	insts, _, offset, err := e.FuncInstructions(name)
 	if err != nil {
 		return
@@ -0,0 +1,67 @@
+package elf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+func (e *ELF) GoBuildID() (id string, err error) {
+	desc, err := noteDesc(e.elfFile, ".note.go.buildid")
+	if err != nil {
+		return
+	}
+	return string(desc), nil
+}
+
+func (e *ELF) GNUBuildID() (id string, err error) {
+	desc, err := noteDesc(e.elfFile, ".note.gnu.build-id")
+	if err != nil {
+		return
+	}
+	return hex.EncodeToString(desc), nil
+}
+
+func (e *ELF) BuildID() (id string, err error) {
+	if id, err = e.GoBuildID(); err == nil {
+		return
+	}
+	return e.GNUBuildID()
+}
+
+// ReadBuildID returns the build id of bin without parsing its debug info.
+func ReadBuildID(bin string) (id string, err error) {
+	elfFile, err := elf.Open(bin)
+	if err != nil {
+		return
+	}
+	defer elfFile.Close()
+	e := &ELF{elfFile: elfFile}
+	return e.BuildID()
+}
+
+func noteDesc(elfFile *elf.File, name string) (desc []byte, err error) {
+	section := elfFile.Section(name)
+	if section == nil {
+		err = errors.Wrap(BuildIDNotFoundErr, name)
+		return
+	}
+	note, err := section.Data()
+	if err != nil {
+		return
+	}
+	if len(note) < 12 {
+		err = errors.Wrap(BuildIDNotFoundErr, name)
+		return
+	}
+	namesz := binary.LittleEndian.Uint32(note[0:4])
+	descsz := binary.LittleEndian.Uint32(note[4:8])
+	start := 12 + (namesz+3)&^3
+	if uint64(start)+uint64(descsz) > uint64(len(note)) {
+		err = errors.Wrap(BuildIDNotFoundErr, name)
+		return
+	}
+	return note[start : start+descsz], nil
+}
@@ -0,0 +1,47 @@
+package elf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+func (e *ELF) ReadAddress(addr, size uint64) (bytes []byte, err error) {
+	for _, prog := range e.elfFile.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr+size > prog.Vaddr+prog.Filesz {
+			continue
+		}
+		bytes = make([]byte, size)
+		_, err = prog.ReadAt(bytes, int64(addr-prog.Vaddr))
+		return
+	}
+	err = errors.Wrapf(AddressNotMappedErr, "%x", addr)
+	return
+}
+
+// GoStrings reads a statically initialized global of [...]string, such as
+// runtime.waitReasonStrings.
+func (e *ELF) GoStrings(name string) (strs []string, err error) {
+	sym, err := e.ResolveSymbol(name)
+	if err != nil {
+		return
+	}
+	data, err := e.ReadAddress(sym.Value, sym.Size)
+	if err != nil {
+		return
+	}
+	for i := 0; i+16 <= len(data); i += 16 {
+		ptr, length := binary.LittleEndian.Uint64(data[i:]), binary.LittleEndian.Uint64(data[i+8:])
+		if length == 0 {
+			strs = append(strs, "")
+			continue
+		}
+		str, err := e.ReadAddress(ptr, length)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, string(str))
+	}
+	return
+}
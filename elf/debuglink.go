@@ -0,0 +1,124 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const globalDebugDir = "/usr/lib/debug"
+
+// findDebugFile looks for the debug info split out by objcopy
+// --only-keep-debug, by the build id under .build-id/ and by .gnu_debuglink.
+func (e *ELF) findDebugFile(debugDir string) (path string, err error) {
+	dirs := []string{globalDebugDir}
+	if debugDir != "" {
+		dirs = []string{debugDir, globalDebugDir}
+	}
+
+	ids := []string{}
+	if id, err := e.GNUBuildID(); err == nil {
+		ids = append(ids, id)
+	}
+	if id, err := e.GoBuildID(); err == nil {
+		ids = append(ids, hex.EncodeToString([]byte(id)))
+	}
+	for _, dir := range dirs {
+		for _, id := range ids {
+			if len(id) < 3 {
+				continue
+			}
+			path = filepath.Join(dir, ".build-id", id[:2], id[2:]+".debug")
+			if hasDebugInfo(path) {
+				return path, nil
+			}
+		}
+	}
+
+	binDir := filepath.Dir(e.bin)
+	if absDir, err := filepath.Abs(binDir); err == nil {
+		binDir = absDir
+	}
+	if name, crc, err := e.debugLink(); err == nil {
+		candidates := []string{
+			filepath.Join(binDir, name),
+			filepath.Join(binDir, ".debug", name),
+			filepath.Join(globalDebugDir, binDir, name),
+		}
+		if debugDir != "" {
+			candidates = append([]string{filepath.Join(debugDir, name)}, candidates...)
+		}
+		for _, path = range candidates {
+			if path != e.bin && checkCRC(path, crc) && hasDebugInfo(path) {
+				return path, nil
+			}
+		}
+	}
+
+	if debugDir != "" {
+		for _, path = range []string{
+			filepath.Join(debugDir, filepath.Base(e.bin)+".debug"),
+			filepath.Join(debugDir, filepath.Base(e.bin)),
+		} {
+			if hasDebugInfo(path) {
+				return path, nil
+			}
+		}
+	}
+	return "", errors.Wrap(DebugInfoNotFoundErr, e.bin)
+}
+
+// debugLink parses .gnu_debuglink, which holds the file name of the debug
+// info and the crc32 of it.
+func (e *ELF) debugLink() (name string, crc uint32, err error) {
+	section := e.Section(".gnu_debuglink")
+	if section == nil {
+		err = errors.Wrap(SectionNotFoundErr, ".gnu_debuglink")
+		return
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 {
+		err = errors.Wrap(DebugInfoNotFoundErr, ".gnu_debuglink")
+		return
+	}
+	// the crc is 4-byte aligned after the nul-terminated name
+	crcOffset := (end + 4) &^ 3
+	if crcOffset+4 > len(data) {
+		err = errors.Wrap(DebugInfoNotFoundErr, ".gnu_debuglink")
+		return
+	}
+	return string(data[:end]), binary.LittleEndian.Uint32(data[crcOffset:]), nil
+}
+
+func checkCRC(path string, crc uint32) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && crc32.ChecksumIEEE(data) == crc
+}
+
+func hasDebugInfo(path string) bool {
+	elfFile, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer elfFile.Close()
+	return hasDwarf(elfFile)
+}
+
+func hasDwarf(elfFile *elf.File) bool {
+	for _, name := range []string{".debug_info", ".zdebug_info"} {
+		if section := elfFile.Section(name); section != nil && section.Type != elf.SHT_NOBITS {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package elf
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// diskCacheVersion is bumped whenever the analysis or the layout of
+// diskCache changes, so that stale caches are ignored.
+const diskCacheVersion = 1
+
+// diskCache persists the analysis of the binary across runs, keyed by the
+// build id under $XDG_CACHE_HOME/gofuncgraph.
+type diskCache struct {
+	Version    int
+	Dwarf      bool
+	FuncRanges map[string][2]uint64
+	RetOffsets map[string][]uint64
+	Lines      []lineInfo
+	GoidOffset int64
+
+	dirty bool
+}
+
+type lineInfo struct {
+	Address uint64
+	File    string
+	Line    int
+}
+
+func (e *ELF) diskCachePath() (path string, err error) {
+	id, err := e.BuildID()
+	if err != nil {
+		return
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.Join(dir, "gofuncgraph", strings.ReplaceAll(id, "/", "-")+".gob"), nil
+}
+
+// diskCache returns the cache loaded from disk, or an empty one if it is
+// missing or stale.
+func (e *ELF) diskCache() *diskCache {
+	if e.disk != nil {
+		return e.disk
+	}
+	e.disk = &diskCache{
+		Version:    diskCacheVersion,
+		Dwarf:      e.dwarfData != nil,
+		RetOffsets: map[string][]uint64{},
+	}
+	path, err := e.diskCachePath()
+	if err != nil {
+		return e.disk
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return e.disk
+	}
+	defer file.Close()
+	disk := &diskCache{}
+	if err = gob.NewDecoder(file).Decode(disk); err == nil && disk.Version == e.disk.Version && disk.Dwarf == e.disk.Dwarf {
+		if disk.RetOffsets == nil {
+			disk.RetOffsets = map[string][]uint64{}
+		}
+		e.disk = disk
+	}
+	return e.disk
+}
+
+// SaveCache writes the analysis done in this run back to disk.
+func (e *ELF) SaveCache() (err error) {
+	disk := e.diskCache()
+	if !disk.dirty {
+		return
+	}
+	path, err := e.diskCachePath()
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(file.Name())
+	if err = gob.NewEncoder(file).Encode(disk); err != nil {
+		file.Close()
+		return errors.WithStack(err)
+	}
+	if err = file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return errors.WithStack(err)
+	}
+	disk.dirty = false
+	return
+}
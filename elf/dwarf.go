@@ -2,89 +2,54 @@ package elf
 
 import (
 	"debug/dwarf"
+	"encoding/binary"
 	"io"
 	"sort"
 
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
 	"github.com/pkg/errors"
 )
 
-func (e *ELF) IterDebugInfo() <-chan *dwarf.Entry {
-	ch := make(chan *dwarf.Entry)
-	go func() {
-		defer close(ch)
-		infoReader := e.dwarfData.Reader()
-		for {
-			entry, err := infoReader.Next()
-			if err != nil || entry == nil {
-				return
-			}
-			ch <- entry
-		}
-	}()
-	return ch
-}
-
-func (e *ELF) NonInlinedSubprogramDIEs() (dies map[string]*dwarf.Entry, err error) {
-	if v, ok := e.cache["subprogramdies"]; ok {
-		return v.(map[string]*dwarf.Entry), nil
-	}
-
-	_, symnames, err := e.Symbols()
+func (e *ELF) FuncPcRangeInDwarf(funcname string) (lowpc, highpc uint64, err error) {
+	ranges, err := e.funcRanges()
 	if err != nil {
 		return
 	}
 
-	dies = map[string]*dwarf.Entry{}
-	for die := range e.IterDebugInfo() {
-		if die.Tag == dwarf.TagSubprogram {
-			v := die.Val(dwarf.AttrName)
-			if v == nil {
-				continue
-			}
-			name := v.(string)
-			v = die.Val(dwarf.AttrLowpc)
-			if v == nil {
-				continue
-			}
-			lowpc := v.(uint64)
-			v = die.Val(dwarf.AttrHighpc)
-			if v == nil {
-				continue
-			}
-
-			sym, ok := symnames[name]
-			if !ok {
-				continue
-			}
-			if sym.Value != lowpc {
-				continue
-			}
-			dies[name] = die
-		}
+	r, ok := ranges[funcname]
+	if !ok {
+		err = errors.WithMessage(DIENotFoundError, funcname)
+		return
 	}
-	e.cache["subprogramdies"] = dies
-	return dies, nil
+	return r[0], r[1], nil
 }
 
-func (e *ELF) FuncPcRangeInDwarf(funcname string) (lowpc, highpc uint64, err error) {
-	dies, err := e.NonInlinedSubprogramDIEs()
+func (e *ELF) funcRanges() (ranges map[string][2]uint64, err error) {
+	disk := e.diskCache()
+	if disk.FuncRanges != nil {
+		return disk.FuncRanges, nil
+	}
+
+	index, err := e.dwarfIndex()
 	if err != nil {
 		return
 	}
-
-	die, ok := dies[funcname]
-	if !ok {
-		err = errors.WithMessage(DIENotFoundError, funcname)
+	_, symnames, err := e.Symbols()
+	if err != nil {
 		return
 	}
-
-	lowpc = die.Val(dwarf.AttrLowpc).(uint64)
-	switch v := die.Val(dwarf.AttrHighpc).(type) {
-	case uint64:
-		highpc = v
-	case int64:
-		highpc = lowpc + uint64(v)
+	ranges = map[string][2]uint64{}
+	for name, sps := range index.subprograms {
+		for _, sp := range sps {
+			// skip the subprograms not matching the symbol, such as
+			// the wrappers of the same name
+			if sym, ok := symnames[name]; ok && sym.Value == sp.lowpc {
+				ranges[name] = [2]uint64{sp.lowpc, sp.highpc}
+			}
+		}
 	}
+	disk.FuncRanges = ranges
+	disk.dirty = true
 	return
 }
 
@@ -92,24 +57,25 @@ func (e *ELF) LineEntries() (lineEntries []dwarf.LineEntry, err error) {
 	if v, ok := e.cache["lineEntries"]; ok {
 		return v.([]dwarf.LineEntry), nil
 	}
-	for die := range e.IterDebugInfo() {
-		if die.Tag == dwarf.TagCompileUnit {
-			var lineReader *dwarf.LineReader
-			lineReader, err = e.dwarfData.LineReader(die)
-			if err != nil || lineReader == nil {
-				continue
-			}
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	for _, unit := range index.units {
+		lineReader, err := e.dwarfData.LineReader(unit)
+		if err != nil || lineReader == nil {
+			continue
+		}
 
-			for {
-				entry := dwarf.LineEntry{}
-				if err = lineReader.Next(&entry); err != nil {
-					if err == io.EOF {
-						break
-					}
-					return
+		for {
+			entry := dwarf.LineEntry{}
+			if err = lineReader.Next(&entry); err != nil {
+				if err == io.EOF {
+					break
 				}
-				lineEntries = append(lineEntries, entry)
+				return nil, err
 			}
+			lineEntries = append(lineEntries, entry)
 		}
 	}
 	sort.Slice(lineEntries, func(i, j int) bool { return lineEntries[i].Address < lineEntries[j].Address })
@@ -118,45 +84,150 @@ func (e *ELF) LineEntries() (lineEntries []dwarf.LineEntry, err error) {
 }
 
 func (e *ELF) LineInfoForPc(pc uint64) (filename string, line int, err error) {
+	if e.dwarfData == nil {
+		return e.pclntabLineInfoForPc(pc)
+	}
+	lines, err := e.lineTable()
+	if err != nil {
+		return
+	}
+	idx := sort.Search(len(lines), func(i int) bool { return lines[i].Address >= pc }) - 1
+	if idx < 0 {
+		err = errors.Wrapf(DIENotFoundError, "line for %x", pc)
+		return
+	}
+	return lines[idx].File, lines[idx].Line, nil
+}
+
+func (e *ELF) lineTable() (lines []lineInfo, err error) {
+	disk := e.diskCache()
+	if disk.Lines != nil {
+		return disk.Lines, nil
+	}
+
 	lineEntries, err := e.LineEntries()
 	if err != nil {
 		return
 	}
-	idx := sort.Search(len(lineEntries), func(i int) bool { return lineEntries[i].Address >= pc }) - 1
-	return lineEntries[idx].File.Name, lineEntries[idx].Line, nil
+	lines = make([]lineInfo, 0, len(lineEntries))
+	for _, entry := range lineEntries {
+		lines = append(lines, lineInfo{Address: entry.Address, File: entry.File.Name, Line: entry.Line})
+	}
+	disk.Lines = lines
+	disk.dirty = true
+	return
+}
+
+func (e *ELF) FindGoidOffset() (offset int64, err error) {
+	disk := e.diskCache()
+	if disk.GoidOffset != 0 {
+		return disk.GoidOffset, nil
+	}
+	if offset, err = e.findGoidOffsetInDwarf(); err == nil {
+		disk.GoidOffset = offset
+		disk.dirty = true
+		return
+	}
+	rt, err := e.Runtime()
+	if err != nil {
+		return
+	}
+	return rt.GoidOffset, nil
 }
 
-func (e *ELF) FindGoidOffset() (int64, error) {
-	foundRuntimeG := false
-	for die := range e.IterDebugInfo() {
-		switch die.Tag {
-		case dwarf.TagStructType:
-			v := die.Val(dwarf.AttrName)
-			if v == nil {
-				continue
+func (e *ELF) findGoidOffsetInDwarf() (offset int64, err error) {
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	structOffset, ok := index.types["runtime.g"]
+	if !ok {
+		err = errors.Wrap(DIENotFoundError, "runtime.g")
+		return
+	}
+	_, reader, err := e.entryAt(structOffset)
+	if err != nil {
+		return
+	}
+	for {
+		member, err := reader.Next()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if member == nil || member.Tag != dwarf.TagMember {
+			break
+		}
+		if name, _ := member.Val(dwarf.AttrName).(string); name == "goid" {
+			if offset, ok := member.Val(dwarf.AttrDataMemberLoc).(int64); ok {
+				return offset, nil
 			}
-			name := v.(string)
-			if name != "runtime.g" {
-				continue
+		}
+	}
+	return 0, errors.Wrap(DIENotFoundError, "runtime.g.goid")
+}
+
+// RuntimeTypeName returns the name of the type whose runtime type descriptor
+// is at addr, such as the type word of an interface.
+func (e *ELF) RuntimeTypeName(addr uint64) (name string, err error) {
+	if _, ok := e.cache["runtimetypes"]; !ok {
+		// newer toolchains emit the offset from runtime.types instead of
+		// the address
+		base, err := e.ResolveSymbol("runtime.types")
+		if err != nil {
+			return "", err
+		}
+		index, err := e.dwarfIndex()
+		if err != nil {
+			return "", err
+		}
+		types := map[uint64]string{}
+		for addr, name := range index.runtimeTypes {
+			if addr < base.Value {
+				addr += base.Value
 			}
-			foundRuntimeG = true
-		case dwarf.TagMember:
-			if foundRuntimeG {
-				v := die.Val(dwarf.AttrName)
-				if v == nil {
-					continue
-				}
-				name := v.(string)
-				if name != "goid" {
-					continue
-				}
-				v = die.Val(dwarf.AttrDataMemberLoc)
-				if v == nil {
-					continue
+			types[addr] = name
+		}
+		e.cache["runtimetypes"] = types
+	}
+	name, ok := e.cache["runtimetypes"].(map[uint64]string)[addr]
+	if !ok {
+		err = errors.Wrapf(DIENotFoundError, "runtime type %x", addr)
+	}
+	return
+}
+
+// unitHeader is the start of a unit in .debug_info and its DWARF version.
+type unitHeader struct {
+	offset  dwarf.Offset
+	version uint16
+}
+
+// unitVersion returns the DWARF version of the unit whose entry is at
+// offset, which debug/dwarf doesn't expose.
+func (e *ELF) unitVersion(offset dwarf.Offset) (version uint16, err error) {
+	if _, ok := e.cache["unitHeaders"]; !ok {
+		info, err := godwarf.GetDebugSectionElf(e.debugFile, "info")
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		headers := []unitHeader{}
+		for start := uint64(0); start+6 <= uint64(len(info)); {
+			length, size := uint64(binary.LittleEndian.Uint32(info[start:])), uint64(4)
+			if length == 0xffffffff {
+				if start+14 > uint64(len(info)) {
+					break
 				}
-				return v.(int64), nil
+				length, size = binary.LittleEndian.Uint64(info[start+4:]), 12
 			}
+			headers = append(headers, unitHeader{offset: dwarf.Offset(start), version: binary.LittleEndian.Uint16(info[start+size:])})
+			start += size + length
 		}
+		e.cache["unitHeaders"] = headers
+	}
+	headers := e.cache["unitHeaders"].([]unitHeader)
+	idx := sort.Search(len(headers), func(i int) bool { return headers[i].offset > offset }) - 1
+	if idx < 0 {
+		return 0, errors.Wrapf(DIENotFoundError, "unit at %x", offset)
 	}
-	return 0, errors.New("goid not found")
+	return headers[idx].version, nil
 }
@@ -12,12 +12,24 @@ type ELF struct {
 	bin       string
 	binFile   *os.File
 	elfFile   *elf.File
+	debugBin  string
+	debugFile *elf.File
 	dwarfData *dwarf.Data
+	goVersion string
+	disk      *diskCache
+	index     *dwarfIndex
 
 	cache map[string]interface{}
 }
 
-func New(bin string) (_ *ELF, err error) {
+type Options struct {
+	// DebugDir is searched for the separate debug info before /usr/lib/debug.
+	DebugDir string
+	// GoVersion overrides the version from the buildinfo, e.g. go1.21.
+	GoVersion string
+}
+
+func New(bin string, opts Options) (_ *ELF, err error) {
 	binFile, err := os.Open(bin)
 	if err != nil {
 		return
@@ -26,50 +38,85 @@ func New(bin string) (_ *ELF, err error) {
 	if err != nil {
 		return
 	}
-	abbrev, err := godwarf.GetDebugSectionElf(elfFile, "abbrev")
+	e := &ELF{
+		bin:       bin,
+		binFile:   binFile,
+		elfFile:   elfFile,
+		debugBin:  bin,
+		debugFile: elfFile,
+		goVersion: opts.GoVersion,
+		cache:     map[string]interface{}{},
+	}
+	if !hasDwarf(elfFile) {
+		debugBin, err := e.findDebugFile(opts.DebugDir)
+		if err != nil {
+			// stripped binaries are symbolized by .gopclntab
+			return e, nil
+		}
+		if e.debugFile, err = elf.Open(debugBin); err != nil {
+			return nil, err
+		}
+		e.debugBin = debugBin
+	}
+
+	abbrev, err := godwarf.GetDebugSectionElf(e.debugFile, "abbrev")
 	if err != nil {
 		abbrev = nil
 	}
-	aranges, err := godwarf.GetDebugSectionElf(elfFile, "aranges")
+	aranges, err := godwarf.GetDebugSectionElf(e.debugFile, "aranges")
 	if err != nil {
 		aranges = nil
 	}
-	frame, err := godwarf.GetDebugSectionElf(elfFile, "frame")
+	frame, err := godwarf.GetDebugSectionElf(e.debugFile, "frame")
 	if err != nil {
-		section := elfFile.Section(".eh_frame")
-		frame = make([]byte, section.Size)
-		_, err = binFile.ReadAt(frame, int64(section.Offset))
+		frame = nil
+		if section := elfFile.Section(".eh_frame"); section != nil {
+			frame = make([]byte, section.Size)
+			_, err = binFile.ReadAt(frame, int64(section.Offset))
+		}
 	}
-	info, err := godwarf.GetDebugSectionElf(elfFile, "info")
+	info, err := godwarf.GetDebugSectionElf(e.debugFile, "info")
 	if err != nil {
-		info = nil
+		return e, nil
 	}
-	line, err := godwarf.GetDebugSectionElf(elfFile, "line")
+	line, err := godwarf.GetDebugSectionElf(e.debugFile, "line")
 	if err != nil {
 		line = nil
 	}
-	pubnames, err := godwarf.GetDebugSectionElf(elfFile, "pubnames")
+	pubnames, err := godwarf.GetDebugSectionElf(e.debugFile, "pubnames")
 	if err != nil {
 		pubnames = nil
 	}
-	ranges, err := godwarf.GetDebugSectionElf(elfFile, "ranges")
+	ranges, err := godwarf.GetDebugSectionElf(e.debugFile, "ranges")
 	if err != nil {
 		ranges = nil
 	}
-	str, err := godwarf.GetDebugSectionElf(elfFile, "str")
+	str, err := godwarf.GetDebugSectionElf(e.debugFile, "str")
 	if err != nil {
 		str = nil
 	}
-	dwarfData, err := dwarf.New(abbrev, aranges, frame, info, line, pubnames, ranges, str)
-	if err != nil {
-		println("...")
+	if e.dwarfData, err = dwarf.New(abbrev, aranges, frame, info, line, pubnames, ranges, str); err != nil {
 		return
 	}
-	return &ELF{
-		bin:       bin,
-		binFile:   binFile,
-		elfFile:   elfFile,
-		dwarfData: dwarfData,
-		cache:     map[string]interface{}{},
-	}, nil
+	// DWARF5, the default since go1.25, refers to these sections by the
+	// attributes of the units
+	for _, name := range []string{"addr", "line_str", "str_offsets", "rnglists", "loclists"} {
+		data, err := godwarf.GetDebugSectionElf(e.debugFile, name)
+		if err != nil {
+			continue
+		}
+		if err = e.dwarfData.AddSection(".debug_"+name, data); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// DebugBin returns the file the debug info is read from, which is the binary
+// itself unless the debug info is split out.
+func (e *ELF) DebugBin() string {
+	if e.dwarfData == nil {
+		return ""
+	}
+	return e.debugBin
 }
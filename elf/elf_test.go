@@ -0,0 +1,113 @@
+package elf
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildTestBinary builds testdata/dwarf by the go toolchain in PATH.
+func buildTestBinary(t *testing.T, env ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not found")
+	}
+	bin := filepath.Join(t.TempDir(), "dwarf")
+	cmd := exec.Command("go", "build", "-o", bin, "./testdata/dwarf/main.go")
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+type testBuild struct {
+	name string
+	env  []string
+}
+
+var dwarfVersions = []testBuild{
+	{name: "default"},
+	{name: "nodwarf5", env: []string{"GOEXPERIMENT=nodwarf5"}},
+}
+
+func TestDwarfVersions(t *testing.T) {
+	for _, tt := range dwarfVersions {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(buildTestBinary(t, tt.env...), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lowpc, highpc, err := e.FuncPcRangeInDwarf("main.scale")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sym, err := e.ResolveSymbol("main.scale"); err != nil || sym.Value != lowpc || lowpc >= highpc {
+				t.Fatalf("range of main.scale: got [%x, %x), want to start at symbol %x (%v)", lowpc, highpc, sym.Value, err)
+			}
+			filename, line, err := e.LineInfoForPc(lowpc + 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(filename, "testdata/dwarf/main.go") || line != 13 {
+				t.Errorf("line of main.scale: got %s:%d, want testdata/dwarf/main.go:13", filename, line)
+			}
+			if _, err := e.SubprogramByPc(lowpc + 1); err != nil {
+				t.Error(err)
+			}
+			if _, err := e.findGoidOffsetInDwarf(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFuncParamsLocationLists(t *testing.T) {
+	want := []Param{
+		{Name: "p", Pieces: []Piece{{Size: 8, Register: 0}, {Size: 8, Register: 3}}},
+		{Name: "s", Pieces: []Piece{{Size: 8, Register: 2}, {Size: 8, Register: 5}}},
+		{Name: "n", Pieces: []Piece{{Size: 8, Register: 4}}},
+	}
+	for _, tt := range dwarfVersions {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(buildTestBinary(t, tt.env...), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := e.FuncParams("main.scale")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(params) != len(want) {
+				t.Fatalf("params of main.scale: got %d, want %d", len(params), len(want))
+			}
+			for i, param := range params {
+				if param.Name != want[i].Name || !reflect.DeepEqual(param.Pieces, want[i].Pieces) {
+					t.Errorf("param %d: got %s %+v, want %s %+v", i, param.Name, param.Pieces, want[i].Name, want[i].Pieces)
+				}
+			}
+		})
+	}
+}
+
+func TestNewprocRegisters(t *testing.T) {
+	for _, tt := range append(dwarfVersions, testBuild{name: "nodwarf", env: []string{"GOFLAGS=-ldflags=-w"}}) {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(buildTestBinary(t, tt.env...), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// go1.18+ passes newproc1(fn, callergp, callerpc) in AX, BX, CX
+			callergp, callerpc, err := e.NewprocRegisters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callergp != 3 || callerpc != 2 {
+				t.Errorf("registers of newproc1: got %d, %d, want 3, 2", callergp, callerpc)
+			}
+		})
+	}
+}
@@ -10,4 +10,10 @@ var (
 	PcRangeTooLargeErr      = errors.New("PC range too large")
 	FramePointerNotFoundErr = errors.New("framepointer not found")
 	RetNotFoundErr          = errors.New("ret not found")
+	BuildIDNotFoundErr      = errors.New("build id not found")
+	AddressNotMappedErr     = errors.New("address not mapped")
+	SectionNotFoundErr      = errors.New("section not found")
+	GoVersionUnknownErr     = errors.New("go version unknown")
+	GoVersionUnsupportedErr = errors.New("go version unsupported")
+	DebugInfoNotFoundErr    = errors.New("debug info not found")
 )
@@ -1,6 +1,10 @@
 package elf
 
-import "debug/elf"
+import (
+	"debug/elf"
+
+	"github.com/pkg/errors"
+)
 
 func (f *ELF) Section(s string) *elf.Section {
 	return f.elfFile.Section(s)
@@ -13,9 +17,32 @@ func (f *ELF) SectionBytes(s string) (bytes []byte, err error) {
 	return
 }
 
+// AddressToOffset translates the link-time address to the file offset by the
+// PT_LOAD segment containing it.
 func (f *ELF) AddressToOffset(addr uint64) (offset uint64, err error) {
-	textSection := f.Section(".text")
-	return addr - textSection.Addr, nil
+	for _, prog := range f.elfFile.Progs {
+		if prog.Type == elf.PT_LOAD && addr >= prog.Vaddr && addr < prog.Vaddr+prog.Filesz {
+			return addr - prog.Vaddr + prog.Off, nil
+		}
+	}
+	return 0, errors.Wrapf(AddressNotMappedErr, "%x", addr)
+}
+
+// PIE tells whether the binary is position independent, whose runtime
+// addresses differ from the link-time ones by the load bias.
+func (f *ELF) PIE() bool {
+	return f.elfFile.Type == elf.ET_DYN
+}
+
+// LoadBias calculates the load bias from the start address of the mapping of
+// the file offset.
+func (f *ELF) LoadBias(start, offset uint64) (bias uint64, err error) {
+	for _, prog := range f.elfFile.Progs {
+		if prog.Type == elf.PT_LOAD && offset >= prog.Off && offset < prog.Off+prog.Filesz {
+			return start - (prog.Vaddr + offset - prog.Off), nil
+		}
+	}
+	return 0, errors.Wrapf(AddressNotMappedErr, "offset %x", offset)
 }
 
 func (e *ELF) Prog(typ elf.ProgType) *elf.Prog {
@@ -0,0 +1,145 @@
+package elf
+
+import (
+	"debug/dwarf"
+	"sort"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/pkg/errors"
+)
+
+// dwarfIndex is built by a single pass over .debug_info, which only keeps the
+// offsets of the DIEs so that they are read on demand.
+type dwarfIndex struct {
+	units        []*dwarf.Entry
+	subprograms  map[string][]subprogram
+	pcs          []subprogram
+	types        map[string]dwarf.Offset
+	runtimeTypes map[uint64]string
+}
+
+// subprogram is a concrete subprogram DIE, which has a pc range.
+type subprogram struct {
+	name   string
+	lowpc  uint64
+	highpc uint64
+	offset dwarf.Offset
+	// unit is the index of the compile unit in dwarfIndex.units
+	unit int
+}
+
+func (e *ELF) dwarfIndex() (index *dwarfIndex, err error) {
+	if e.index != nil {
+		return e.index, nil
+	}
+	index = &dwarfIndex{
+		subprograms:  map[string][]subprogram{},
+		types:        map[string]dwarf.Offset{},
+		runtimeTypes: map[uint64]string{},
+	}
+	if e.dwarfData == nil {
+		e.index = index
+		return
+	}
+
+	reader := e.dwarfData.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if entry == nil {
+			break
+		}
+		if addr, ok := entry.Val(godwarf.AttrGoRuntimeType).(uint64); ok {
+			index.runtimeTypes[addr], _ = entry.Val(dwarf.AttrName).(string)
+		}
+		if _, ok := entry.Val(godwarf.AttrGoKind).(int64); ok {
+			if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+				index.types[name] = entry.Offset
+			}
+		}
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			index.units = append(index.units, entry)
+			continue
+		case dwarf.TagSubprogram:
+			name, _ := entry.Val(dwarf.AttrName).(string)
+			lowpc, ok := entry.Val(dwarf.AttrLowpc).(uint64)
+			if name != "" && ok {
+				sp := subprogram{name: name, lowpc: lowpc, offset: entry.Offset, unit: len(index.units) - 1}
+				switch v := entry.Val(dwarf.AttrHighpc).(type) {
+				case uint64:
+					sp.highpc = v
+				case int64:
+					sp.highpc = lowpc + uint64(v)
+				}
+				if sp.highpc > 0 {
+					index.subprograms[name] = append(index.subprograms[name], sp)
+					index.pcs = append(index.pcs, sp)
+				}
+			}
+		}
+		if entry.Children {
+			reader.SkipChildren()
+		}
+	}
+	sort.Slice(index.pcs, func(i, j int) bool { return index.pcs[i].lowpc < index.pcs[j].lowpc })
+	e.index = index
+	return
+}
+
+// entryAt reads the DIE at offset, whose children follow by reader.Next.
+func (e *ELF) entryAt(offset dwarf.Offset) (entry *dwarf.Entry, reader *dwarf.Reader, err error) {
+	if e.dwarfData == nil {
+		err = errors.Wrapf(DIENotFoundError, "%x", offset)
+		return
+	}
+	reader = e.dwarfData.Reader()
+	reader.Seek(offset)
+	if entry, err = reader.Next(); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if entry == nil {
+		err = errors.Wrapf(DIENotFoundError, "%x", offset)
+	}
+	return
+}
+
+// SubprogramByName returns the concrete subprogram DIE of the function.
+func (e *ELF) SubprogramByName(name string) (entry *dwarf.Entry, err error) {
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	sps := index.subprograms[name]
+	if len(sps) == 0 {
+		err = errors.WithMessage(DIENotFoundError, name)
+		return
+	}
+	sp := sps[0]
+	if sym, err := e.ResolveSymbol(name); err == nil {
+		for _, s := range sps {
+			if s.lowpc == sym.Value {
+				sp = s
+			}
+		}
+	}
+	entry, _, err = e.entryAt(sp.offset)
+	return
+}
+
+// SubprogramByPc returns the concrete subprogram DIE containing pc.
+func (e *ELF) SubprogramByPc(pc uint64) (entry *dwarf.Entry, err error) {
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	idx := sort.Search(len(index.pcs), func(i int) bool { return index.pcs[i].lowpc > pc }) - 1
+	if idx < 0 || pc >= index.pcs[idx].highpc {
+		err = errors.Wrapf(DIENotFoundError, "subprogram for %x", pc)
+		return
+	}
+	entry, _, err = e.entryAt(index.pcs[idx].offset)
+	return
+}
@@ -0,0 +1,90 @@
+package elf
+
+import (
+	"debug/dwarf"
+	"testing"
+)
+
+// TestDwarfIndex checks the lookups of subprograms by name and pc against a
+// linear scan of .debug_info.
+func TestDwarfIndex(t *testing.T) {
+	for _, tt := range dwarfVersions {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(buildTestBinary(t, tt.env...), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			type subprogram struct {
+				lowpc, highpc uint64
+			}
+			subprograms := map[dwarf.Offset]subprogram{}
+			names := map[string][]dwarf.Offset{}
+			reader := e.dwarfData.Reader()
+			for {
+				entry, err := reader.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry == nil {
+					break
+				}
+				if entry.Tag != dwarf.TagSubprogram {
+					continue
+				}
+				name, _ := entry.Val(dwarf.AttrName).(string)
+				ranges, err := e.dwarfData.Ranges(entry)
+				if err != nil || name == "" || len(ranges) != 1 {
+					continue
+				}
+				subprograms[entry.Offset] = subprogram{lowpc: ranges[0][0], highpc: ranges[0][1]}
+				names[name] = append(names[name], entry.Offset)
+			}
+			if len(subprograms) == 0 {
+				t.Fatal("no subprogram found")
+			}
+
+			for name, offsets := range names {
+				entry, err := e.SubprogramByName(name)
+				if err != nil {
+					t.Errorf("SubprogramByName(%s): %v", name, err)
+					continue
+				}
+				found := false
+				for _, offset := range offsets {
+					found = found || entry.Offset == offset
+				}
+				if !found {
+					t.Errorf("SubprogramByName(%s): got DIE at %x, want one of %x", name, entry.Offset, offsets)
+				}
+			}
+			if _, err := e.SubprogramByName("main.nonexistent"); err == nil {
+				t.Error("SubprogramByName(main.nonexistent): got no error")
+			}
+
+			for offset, sp := range subprograms {
+				for _, pc := range []uint64{sp.lowpc, (sp.lowpc + sp.highpc) / 2, sp.highpc - 1} {
+					entry, err := e.SubprogramByPc(pc)
+					if err != nil {
+						t.Errorf("SubprogramByPc(%x): %v", pc, err)
+						continue
+					}
+					if entry.Offset != offset {
+						t.Errorf("SubprogramByPc(%x): got DIE at %x, want %x", pc, entry.Offset, offset)
+					}
+				}
+			}
+
+			_, highpc, err := e.FuncPcRangeInDwarf("main.scale")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry, err := e.SubprogramByPc(highpc); err == nil && entry.Offset == names["main.scale"][0] {
+				t.Errorf("SubprogramByPc(%x): got main.scale ending at %x", highpc, highpc)
+			}
+			if _, err := e.SubprogramByPc(0); err == nil {
+				t.Error("SubprogramByPc(0): got no error")
+			}
+		})
+	}
+}
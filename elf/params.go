@@ -0,0 +1,237 @@
+package elf
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/dwarf/loclist"
+	"github.com/go-delve/delve/pkg/dwarf/op"
+	"github.com/go-delve/delve/pkg/dwarf/regnum"
+	"github.com/pkg/errors"
+)
+
+// Param is a formal parameter of a function, located at the entry of the
+// function.
+type Param struct {
+	Name   string
+	Type   godwarf.Type
+	Pieces []Piece
+}
+
+// Piece is a part of the parameter, which is either in a register or on the
+// stack.
+type Piece struct {
+	Size int64
+	// Register is the DWARF register number, or -1 if on the stack
+	Register int
+	// Offset is the offset from rsp at the function entry if on the stack
+	Offset int64
+}
+
+// FuncParams returns the formal parameters of the function by their DWARF
+// locations at the entry pc.
+func (e *ELF) FuncParams(funcname string) (params []Param, err error) {
+	formals, err := e.formalParams(funcname)
+	if err != nil {
+		return
+	}
+	for _, formal := range formals {
+		// skip the return values
+		if formal.output {
+			continue
+		}
+		if formal.Pieces, err = e.locationAt(formal.entry, formal.unit, formal.lowpc); err != nil {
+			return nil, errors.WithMessagef(err, "location of %s in %s", formal.Name, funcname)
+		}
+		if len(formal.Pieces) == 1 && formal.Pieces[0].Size == 0 {
+			formal.Pieces[0].Size = formal.Type.Size()
+		}
+		params = append(params, formal.Param)
+	}
+	return
+}
+
+// FuncResults returns the results of the function located at its RET. Go
+// emits no locations for the results, so they are assigned by the register
+// ABI after the parameters.
+func (e *ELF) FuncResults(funcname string) (results []Param, err error) {
+	formals, err := e.formalParams(funcname)
+	if err != nil {
+		return
+	}
+	abi := newABIAssigner()
+	for _, formal := range formals {
+		if !formal.output {
+			abi.assign(formal.Type)
+		}
+	}
+	abi.results()
+	for _, formal := range formals {
+		if formal.output {
+			formal.Pieces = abi.assign(formal.Type)
+			results = append(results, formal.Param)
+		}
+	}
+	return
+}
+
+// formalParam is a formal parameter DIE of a function, including the
+// results.
+type formalParam struct {
+	Param
+	output bool
+	entry  *dwarf.Entry
+	unit   *dwarf.Entry
+	lowpc  uint64
+}
+
+func (e *ELF) formalParams(funcname string) (formals []formalParam, err error) {
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	sub, err := e.SubprogramByName(funcname)
+	if err != nil {
+		return
+	}
+	lowpc, _ := sub.Val(dwarf.AttrLowpc).(uint64)
+	unit := index.units[0]
+	for _, sp := range index.subprograms[funcname] {
+		if sp.offset == sub.Offset && sp.unit >= 0 {
+			unit = index.units[sp.unit]
+		}
+	}
+
+	_, reader, err := e.entryAt(sub.Offset)
+	if err != nil {
+		return
+	}
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if entry == nil || entry.Tag == 0 {
+			break
+		}
+		if entry.Children {
+			reader.SkipChildren()
+		}
+		if entry.Tag != dwarf.TagFormalParameter {
+			continue
+		}
+
+		formal := formalParam{entry: entry, unit: unit, lowpc: lowpc}
+		formal.Name, _ = entry.Val(dwarf.AttrName).(string)
+		formal.output, _ = entry.Val(dwarf.AttrVarParam).(bool)
+		typeOffset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return nil, errors.Wrapf(DIENotFoundError, "type of %s in %s", formal.Name, funcname)
+		}
+		if formal.Type, err = e.ReadType(typeOffset); err != nil {
+			return nil, err
+		}
+		formals = append(formals, formal)
+	}
+	return
+}
+
+// ReadType reads the DWARF type at offset.
+func (e *ELF) ReadType(offset dwarf.Offset) (typ godwarf.Type, err error) {
+	if _, ok := e.cache["types"]; !ok {
+		e.cache["types"] = map[dwarf.Offset]godwarf.Type{}
+	}
+	if typ, err = godwarf.ReadType(e.dwarfData, 0, offset, e.cache["types"].(map[dwarf.Offset]godwarf.Type)); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// locationAt evaluates the location of the variable at pc, which is either a
+// location expression or a location list.
+func (e *ELF) locationAt(entry *dwarf.Entry, unit *dwarf.Entry, pc uint64) (pieces []Piece, err error) {
+	var instr []byte
+	switch v := entry.Val(dwarf.AttrLocation).(type) {
+	case []byte:
+		instr = v
+	case int64, uint64:
+		locEntry, err := e.findLocation(unit, v, pc)
+		if err != nil {
+			return nil, err
+		}
+		if locEntry == nil {
+			return nil, errors.Wrapf(DIENotFoundError, "location list at %x", pc)
+		}
+		instr = locEntry.Instr
+	default:
+		return nil, errors.Wrap(DIENotFoundError, "location")
+	}
+
+	// the CFA is right above the return address pushed by the call at the
+	// function entry, so the stack addresses are offsets from rsp
+	regs := op.NewDwarfRegisters(0, make([]*op.DwarfRegister, regnum.AMD64_Rip+1), binary.LittleEndian, regnum.AMD64_Rip, regnum.AMD64_Rsp, regnum.AMD64_Rbp, 0)
+	regs.CFA = 8
+	regs.FrameBase = 8
+	addr, opPieces, err := op.ExecuteStackProgram(*regs, instr, 8, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if opPieces == nil {
+		return []Piece{{Register: -1, Offset: addr}}, nil
+	}
+	for _, p := range opPieces {
+		switch p.Kind {
+		case op.RegPiece:
+			pieces = append(pieces, Piece{Size: int64(p.Size), Register: int(p.Val)})
+		case op.AddrPiece:
+			pieces = append(pieces, Piece{Size: int64(p.Size), Register: -1, Offset: int64(p.Val)})
+		default:
+			return nil, errors.Wrap(DIENotFoundError, "optimized out")
+		}
+	}
+	return
+}
+
+// findLocation finds the entry at pc in the location list of the attribute,
+// which is an offset into .debug_loc before DWARF5, and an offset or an
+// index of DW_FORM_loclistx into .debug_loclists since DWARF5.
+func (e *ELF) findLocation(unit *dwarf.Entry, attr interface{}, pc uint64) (_ *loclist.Entry, err error) {
+	version, err := e.unitVersion(unit.Offset)
+	if err != nil {
+		return
+	}
+	base, _ := unit.Val(dwarf.AttrLowpc).(uint64)
+	if version < 5 {
+		loc, err := godwarf.GetDebugSectionElf(e.debugFile, "loc")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		offset, _ := attr.(int64)
+		entry, err := loclist.NewDwarf2Reader(loc, 8).Find(int(offset), 0, base, pc, nil)
+		return entry, errors.WithStack(err)
+	}
+
+	loclists, err := godwarf.GetDebugSectionElf(e.debugFile, "loclists")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	offset, ok := attr.(int64)
+	if !ok {
+		// the index is into the offsets after DW_AT_loclists_base, which
+		// are relative to the base
+		loclistsBase, _ := unit.Val(dwarf.AttrLoclistsBase).(int64)
+		at := loclistsBase + 4*int64(attr.(uint64))
+		if at < 0 || at+4 > int64(len(loclists)) {
+			return nil, errors.Wrapf(DIENotFoundError, "location list %d", attr)
+		}
+		offset = loclistsBase + int64(binary.LittleEndian.Uint32(loclists[at:]))
+	}
+	var debugAddr *godwarf.DebugAddr
+	if addr, err := godwarf.GetDebugSectionElf(e.debugFile, "addr"); err == nil {
+		addrBase, _ := unit.Val(dwarf.AttrAddrBase).(int64)
+		debugAddr = godwarf.ParseAddr(addr).GetSubsection(uint64(addrBase))
+	}
+	entry, err := loclist.NewDwarf5Reader(loclists).Find(int(offset), 0, base, pc, debugAddr)
+	return entry, errors.WithStack(err)
+}
@@ -0,0 +1,66 @@
+package elf
+
+import (
+	"debug/elf"
+	"debug/gosym"
+
+	"github.com/pkg/errors"
+)
+
+// GoSymTable decodes the function table of the Go runtime from .gopclntab,
+// which survives stripping since runtime.moduledata refers to it.
+func (e *ELF) GoSymTable() (table *gosym.Table, err error) {
+	if v, ok := e.cache["gosymtab"]; ok {
+		return v.(*gosym.Table), nil
+	}
+
+	section := e.Section(".gopclntab")
+	if section == nil {
+		// PIE binaries keep it in the relro data
+		section = e.Section(".data.rel.ro.gopclntab")
+	}
+	text := e.Section(".text")
+	if section == nil || text == nil {
+		err = errors.Wrap(SectionNotFoundErr, ".gopclntab")
+		return
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if table, err = gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.cache["gosymtab"] = table
+	return
+}
+
+// pclntabSymbols synthesizes the function symbols from .gopclntab for the
+// binaries without .symtab.
+func (e *ELF) pclntabSymbols() (symbols []elf.Symbol, err error) {
+	table, err := e.GoSymTable()
+	if err != nil {
+		return
+	}
+	for _, fn := range table.Funcs {
+		symbols = append(symbols, elf.Symbol{
+			Name:  fn.Name,
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Value: fn.Entry,
+			Size:  fn.End - fn.Entry,
+		})
+	}
+	return
+}
+
+func (e *ELF) pclntabLineInfoForPc(pc uint64) (filename string, line int, err error) {
+	table, err := e.GoSymTable()
+	if err != nil {
+		return
+	}
+	filename, line, fn := table.PCToLine(pc)
+	if fn == nil {
+		err = errors.Wrapf(SymbolNotFoundError, "%x", pc)
+	}
+	return
+}
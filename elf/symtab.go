@@ -14,7 +14,11 @@ func (e *ELF) Symbols() (symbols []elf.Symbol, symnames map[string]elf.Symbol, e
 	}
 
 	if symbols, err = e.elfFile.Symbols(); err != nil {
-		return
+		if symbols, err = e.debugFile.Symbols(); err != nil {
+			if symbols, err = e.pclntabSymbols(); err != nil {
+				return
+			}
+		}
 	}
 
 	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Value < symbols[j].Value })
@@ -72,8 +76,7 @@ func (e *ELF) FuncOffset(name string) (offset uint64, err error) {
 	if err != nil {
 		return
 	}
-	section := e.Section(".text")
-	return sym.Value - section.Addr + section.Offset, nil
+	return e.AddressToOffset(sym.Value)
 }
 
 func (e *ELF) FuncPcRangeInSymtab(name string) (lowpc, highpc uint64, err error) {
@@ -91,6 +94,8 @@ func (e *ELF) FuncPcRangeInSymtab(name string) (lowpc, highpc uint64, err error)
 	idx := sort.Search(len(symbols), func(i int) bool { return symbols[i].Value > sym.Value })
 	if idx < len(symbols) {
 		highpc = symbols[idx].Value
+	} else {
+		highpc = sym.Value + sym.Size
 	}
 
 	lowpc = sym.Value
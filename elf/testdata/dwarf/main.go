@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type point struct {
+	x, y int
+}
+
+//go:noinline
+func scale(p point, s string, n int) (point, error) {
+	if n < 0 {
+		return p, fmt.Errorf("negative scale %d of %s", n, s)
+	}
+	return point{p.x * n, p.y * n}, nil
+}
+
+func main() {
+	p, err := scale(point{1, 2}, os.Args[0], len(os.Args))
+	fmt.Println(p, err)
+}
@@ -24,9 +24,12 @@ func (e *ELF) FuncRawInstructions(name string) (textBytes []byte, addr, offset u
 		return
 	}
 
-	if highpc > uint64(len(textBytes))+section.Addr || lowpc < section.Addr {
+	if highpc > uint64(len(textBytes))+section.Addr || lowpc < section.Addr || highpc < lowpc {
 		err = errors.Wrap(PcRangeTooLargeErr, name)
 		return
 	}
-	return textBytes[lowpc-section.Addr : highpc-section.Addr], lowpc, lowpc - section.Addr + section.Offset, nil
+	if offset, err = e.AddressToOffset(lowpc); err != nil {
+		return
+	}
+	return textBytes[lowpc-section.Addr : highpc-section.Addr], lowpc, offset, nil
 }
@@ -0,0 +1,112 @@
+package elf
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/pkg/errors"
+)
+
+// offsets in runtime._type, which are stable since go1.14
+const (
+	typeTFlagOffset = 20
+	typeStrOffset   = 40
+
+	tflagExtraStar = 1 << 1
+)
+
+var pkgPathPattern = regexp.MustCompile(`[\w.\-~]+/`)
+
+// TypeByName returns the DWARF type by its name, such as *net/http.response,
+// or the name qualified by the package name only, such as *http.response.
+func (e *ELF) TypeByName(name string) (typ godwarf.Type, err error) {
+	index, err := e.dwarfIndex()
+	if err != nil {
+		return
+	}
+	offset, ok := index.types[name]
+	if !ok {
+		if _, ok := e.cache["shorttypes"]; !ok {
+			short := map[string]string{}
+			for name := range index.types {
+				if s := pkgPathPattern.ReplaceAllString(name, ""); s != name {
+					short[s] = name
+				}
+			}
+			e.cache["shorttypes"] = short
+		}
+		offset, ok = index.types[e.cache["shorttypes"].(map[string]string)[name]]
+	}
+	if !ok {
+		return nil, errors.Wrapf(DIENotFoundError, "type %s", name)
+	}
+	return e.ReadType(offset)
+}
+
+// TypeName returns the name of the type whose runtime type descriptor is at
+// addr. It is looked up in DWARF, then in the type:* symbols, and at last
+// decoded from the name in the descriptor, which works on stripped binaries.
+func (e *ELF) TypeName(addr uint64) (name string, err error) {
+	if name, err = e.RuntimeTypeName(addr); err == nil {
+		return
+	}
+	if syms, offset, err := e.ResolveAddress(addr); err == nil && offset == 0 {
+		for _, sym := range syms {
+			if name, ok := strings.CutPrefix(sym.Name, "type:"); ok && name != "*" {
+				return name, nil
+			}
+			if name, ok := strings.CutPrefix(sym.Name, "type."); ok {
+				return name, nil
+			}
+		}
+	}
+	return e.typeNameInDescriptor(addr)
+}
+
+// typeNameInDescriptor decodes runtime._type.str, which is a nameOff from
+// the start of the type data, pointing to a flag byte, the varint length
+// and the name.
+func (e *ELF) typeNameInDescriptor(addr uint64) (name string, err error) {
+	base, err := e.typesBase()
+	if err != nil {
+		return
+	}
+	typ, err := e.ReadAddress(addr, typeStrOffset+4)
+	if err != nil {
+		return
+	}
+	nameAddr := base + uint64(int32(binary.LittleEndian.Uint32(typ[typeStrOffset:])))
+	header, err := e.ReadAddress(nameAddr, 1+binary.MaxVarintLen16)
+	if err != nil {
+		return
+	}
+	length, n := binary.Uvarint(header[1:])
+	if n <= 0 {
+		return "", errors.Wrapf(AddressNotMappedErr, "name of type %x", addr)
+	}
+	data, err := e.ReadAddress(nameAddr+1+uint64(n), length)
+	if err != nil {
+		return
+	}
+	name = string(data)
+	if typ[typeTFlagOffset]&tflagExtraStar != 0 {
+		name = strings.TrimPrefix(name, "*")
+	}
+	return
+}
+
+// typesBase returns runtime.types, which is the start of .go.type in newer
+// toolchains and the start of .rodata in older ones.
+func (e *ELF) typesBase() (base uint64, err error) {
+	if sym, err := e.ResolveSymbol("runtime.types"); err == nil {
+		return sym.Value, nil
+	}
+	for _, name := range []string{".go.type", ".rodata"} {
+		if section := e.elfFile.Section(name); section != nil {
+			return section.Addr, nil
+		}
+	}
+	return 0, errors.Wrap(SectionNotFoundErr, ".rodata")
+}
@@ -0,0 +1,145 @@
+package elf
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	minGoMinor = 17 // register ABI, g in r14
+	maxGoMinor = 27
+)
+
+// runtimeLayouts lists the changes of the runtime layout since the minor
+// version, which are needed when the binary has no DWARF.
+var runtimeLayouts = []struct {
+	minor      int
+	goidOffset int64
+	// registers of callergp and callerpc passed to runtime.newproc1
+	newprocCallerGp, newprocCallerPc int
+}{
+	{17, 152, 5, 4}, // newproc1(fn, argp, narg, callergp, callerpc)
+	{18, 152, 3, 2}, // newproc1(fn, callergp, callerpc)
+	{23, 160, 3, 2}, // g.syscallbp
+	{25, 152, 3, 2}, // gobuf.ret removed
+}
+
+// Runtime is the layout of the runtime of the binary the tracer relies on.
+type Runtime struct {
+	Version    string
+	GoidOffset int64
+	RegABI     bool
+	// NewprocCallerGp and NewprocCallerPc are the DWARF numbers of the
+	// registers of callergp and callerpc at the entry of runtime.newproc1
+	NewprocCallerGp, NewprocCallerPc int
+}
+
+func (e *ELF) BuildInfo() (info *buildinfo.BuildInfo, err error) {
+	if v, ok := e.cache["buildinfo"]; ok {
+		return v.(*buildinfo.BuildInfo), nil
+	}
+	if info, err = buildinfo.Read(e.binFile); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.cache["buildinfo"] = info
+	return
+}
+
+// GoVersion returns the Go version from the buildinfo, unless overridden by
+// Options.GoVersion.
+func (e *ELF) GoVersion() (version string, err error) {
+	if e.goVersion != "" {
+		return e.goVersion, nil
+	}
+	info, err := e.BuildInfo()
+	if err != nil {
+		return
+	}
+	return info.GoVersion, nil
+}
+
+// Runtime checks the Go version and build settings of the binary against the
+// supported runtime layouts.
+func (e *ELF) Runtime() (rt Runtime, err error) {
+	version, err := e.GoVersion()
+	if err != nil {
+		err = errors.Wrap(GoVersionUnknownErr, "buildinfo not found, specify it by --go-version")
+		return
+	}
+	minor, err := parseGoMinor(version)
+	if err != nil {
+		return
+	}
+	switch {
+	case minor < minGoMinor:
+		err = errors.Wrapf(GoVersionUnsupportedErr, "%s is older than go1.%d", version, minGoMinor)
+		return
+	case minor > maxGoMinor:
+		err = errors.Wrapf(GoVersionUnsupportedErr, "%s is newer than go1.%d, whose runtime layout is unknown", version, maxGoMinor)
+		return
+	}
+
+	rt = Runtime{Version: version, RegABI: true}
+	for _, layout := range runtimeLayouts {
+		if minor >= layout.minor {
+			rt.GoidOffset = layout.goidOffset
+			rt.NewprocCallerGp, rt.NewprocCallerPc = layout.newprocCallerGp, layout.newprocCallerPc
+		}
+	}
+	if info, err := e.BuildInfo(); err == nil {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "GOARCH" && setting.Value != "amd64":
+				return rt, errors.Wrapf(GoVersionUnsupportedErr, "GOARCH=%s", setting.Value)
+			case setting.Key == "GOEXPERIMENT" && strings.Contains(setting.Value, "noregabi"):
+				return rt, errors.Wrapf(GoVersionUnsupportedErr, "GOEXPERIMENT=%s", setting.Value)
+			}
+		}
+	}
+	return rt, nil
+}
+
+// NewprocRegisters returns the registers of callergp and callerpc at the
+// entry of runtime.newproc1 by their DWARF locations, or by the Go version
+// if the binary has no DWARF.
+func (e *ELF) NewprocRegisters() (callergp, callerpc int, err error) {
+	if params, err := e.FuncParams("runtime.newproc1"); err == nil {
+		callergp, callerpc = -1, -1
+		for _, param := range params {
+			if len(param.Pieces) != 1 || param.Pieces[0].Register < 0 {
+				continue
+			}
+			switch param.Name {
+			case "callergp":
+				callergp = param.Pieces[0].Register
+			case "callerpc":
+				callerpc = param.Pieces[0].Register
+			}
+		}
+		if callergp >= 0 && callerpc >= 0 {
+			return callergp, callerpc, nil
+		}
+	}
+	rt, err := e.Runtime()
+	if err != nil {
+		return
+	}
+	return rt.NewprocCallerGp, rt.NewprocCallerPc, nil
+}
+
+// parseGoMinor parses the minor version of go1, such as go1.21.3, go1.22rc1
+// and devel go1.23-abcdef.
+func parseGoMinor(version string) (minor int, err error) {
+	idx := strings.Index(version, "go1.")
+	if idx < 0 {
+		err = errors.Wrap(GoVersionUnknownErr, version)
+		return
+	}
+	if _, err = fmt.Sscanf(version[idx:], "go1.%d", &minor); err != nil {
+		err = errors.Wrap(GoVersionUnknownErr, version)
+	}
+	return
+}
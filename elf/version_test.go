@@ -0,0 +1,88 @@
+package elf
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseGoMinor(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		minor   int
+		err     error
+	}{
+		{version: "go1.17", minor: 17},
+		{version: "go1.21.3", minor: 21},
+		{version: "go1.22rc1", minor: 22},
+		{version: "devel go1.23-abcdef Tue Jan 2 15:04:05 2024 +0000", minor: 23},
+		{version: "go1.27.1", minor: 27},
+		{version: "go1", err: GoVersionUnknownErr},
+		{version: "1.21", err: GoVersionUnknownErr},
+		{version: "", err: GoVersionUnknownErr},
+	} {
+		minor, err := parseGoMinor(tt.version)
+		if errors.Cause(err) != tt.err || minor != tt.minor {
+			t.Errorf("parseGoMinor(%q): got %d, %v, want %d, %v", tt.version, minor, err, tt.minor, tt.err)
+		}
+	}
+}
+
+func TestRuntimeLayouts(t *testing.T) {
+	for _, tt := range []struct {
+		version                          string
+		goidOffset                       int64
+		newprocCallerGp, newprocCallerPc int
+		err                              error
+	}{
+		{version: "go1.16.15", err: GoVersionUnsupportedErr},
+		{version: "go1.17", goidOffset: 152, newprocCallerGp: 5, newprocCallerPc: 4},
+		{version: "go1.18.10", goidOffset: 152, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "go1.22rc1", goidOffset: 152, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "go1.23.0", goidOffset: 160, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "devel go1.24-abcdef", goidOffset: 160, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "go1.25", goidOffset: 152, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "go1.27.1", goidOffset: 152, newprocCallerGp: 3, newprocCallerPc: 2},
+		{version: "go1.28", err: GoVersionUnsupportedErr},
+	} {
+		e := &ELF{goVersion: tt.version, cache: map[string]interface{}{}}
+		rt, err := e.Runtime()
+		if errors.Cause(err) != tt.err {
+			t.Errorf("Runtime of %s: got %v, want %v", tt.version, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if rt.GoidOffset != tt.goidOffset || rt.NewprocCallerGp != tt.newprocCallerGp || rt.NewprocCallerPc != tt.newprocCallerPc {
+			t.Errorf("Runtime of %s: got %+v, want goid at %d and newproc1 in %d, %d", tt.version, rt, tt.goidOffset, tt.newprocCallerGp, tt.newprocCallerPc)
+		}
+	}
+}
+
+// TestRuntimeMatchesDwarf checks the runtime layout of the go toolchain in
+// PATH against its DWARF.
+func TestRuntimeMatchesDwarf(t *testing.T) {
+	e, err := New(buildTestBinary(t), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := e.Runtime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goidOffset, err := e.findGoidOffsetInDwarf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.GoidOffset != goidOffset {
+		t.Errorf("goid offset of %s: got %d, want %d in DWARF", rt.Version, rt.GoidOffset, goidOffset)
+	}
+	callergp, callerpc, err := e.NewprocRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.NewprocCallerGp != callergp || rt.NewprocCallerPc != callerpc {
+		t.Errorf("registers of newproc1 in %s: got %d, %d, want %d, %d in DWARF", rt.Version, rt.NewprocCallerGp, rt.NewprocCallerPc, callergp, callerpc)
+	}
+}
@@ -1,9 +1,9 @@
 module github.com/jschwinger233/gofuncgraph
 
-go 1.20
+go 1.21.0
 
 require (
-	github.com/cilium/ebpf v0.9.0
+	github.com/cilium/ebpf v0.13.2
 	github.com/elastic/go-sysinfo v1.8.0
 	github.com/go-delve/delve v1.8.3
 	github.com/pkg/errors v0.9.1
@@ -11,7 +11,7 @@ require (
 	github.com/urfave/cli/v2 v2.10.3
 	golang.org/x/arch v0.0.0-20220412001346-fc48f9fe4c15
 	golang.org/x/sync v0.1.0
-	golang.org/x/sys v0.0.0-20220627191245-f75cf1eec38b
+	golang.org/x/sys v0.15.0
 )
 
 require (
@@ -22,5 +22,6 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/stretchr/testify v1.7.1 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
+	golang.org/x/exp v0.0.0-20230224173230-c95f2b4c22f2 // indirect
 	howett.net/plist v0.0.0-20181124034731-591f970eefbb // indirect
 )
@@ -1,25 +1,32 @@
 package bpf
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/features"
 	"github.com/cilium/ebpf/link"
 	"github.com/jschwinger233/gofuncgraph/internal/uprobe"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
 )
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -no-strip -target native -type event -type arg_rules -type arg_rule -type arg_data Gofuncgraph ./gofuncgraph.c -- -I./headers
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -no-strip -target native -type event -type arg_rules -type arg_rule -type func_stat -type spawn_data -type park_data -type panic_data -type stack_data Gofuncgraph ./gofuncgraph.c -- -I./headers
 
 const (
-	EventDataOffset int64 = 436
-	VacantR10Offset       = -96
+	MaxDataSize    = 64
+	MaxPayloadSize = 512
 )
 
+var eventSize = binary.Size(GofuncgraphEvent{})
+
 var RegisterConstants = map[string]uint8{
 	"ax":  0,
 	"dx":  1,
@@ -42,26 +49,58 @@ var RegisterConstants = map[string]uint8{
 type LoadOptions struct {
 	GoidOffset int64
 	GOffset    int64
+	Pid        int
+	Stat       bool
+	RegABI     bool
+	// PIE binaries are loaded at LoadBias in Pid, which is only used when
+	// the kernel doesn't support attach cookies.
+	PIE      bool
+	LoadBias uint64
+	// NewprocCallerGp and NewprocCallerPc are the registers of callergp
+	// and callerpc at the entry of runtime.newproc1.
+	NewprocCallerGp, NewprocCallerPc uint8
+	// Threshold drops the root frames faster than it in bpf
+	Threshold time.Duration
 }
 
 type BPF struct {
-	objs    *GofuncgraphObjects
-	closers []io.Closer
+	objs       *GofuncgraphObjects
+	closers    []io.Closer
+	useRingbuf bool
+	useR14     bool
+	useCookie  bool
+	stat       bool
 }
 
 func New() *BPF {
 	return &BPF{}
 }
 
-func (b *BPF) BpfConfig(fetchArgs bool, goidOffset, gOffset int64) interface{} {
+func (b *BPF) BpfConfig(fetchArgs bool, opts LoadOptions) interface{} {
 	return struct {
 		GoidOffset, GOffset int64
+		Pid                 uint32
 		FetchArgs           bool
-		Padding             [7]byte
+		UseRingbuf          bool
+		UseR14              bool
+		UseCookie           bool
+		NewprocGpReg        uint8
+		NewprocPcReg        uint8
+		Padding             [6]byte
+		LoadBias            uint64
+		ThresholdNs         uint64
 	}{
-		GoidOffset: goidOffset,
-		GOffset:    gOffset,
-		FetchArgs:  fetchArgs,
+		GoidOffset:   opts.GoidOffset,
+		GOffset:      opts.GOffset,
+		Pid:          uint32(opts.Pid),
+		FetchArgs:    fetchArgs,
+		UseRingbuf:   b.useRingbuf,
+		UseR14:       b.useR14,
+		UseCookie:    b.useCookie,
+		NewprocGpReg: opts.NewprocCallerGp,
+		NewprocPcReg: opts.NewprocCallerPc,
+		LoadBias:     opts.LoadBias,
+		ThresholdNs:  uint64(opts.Threshold),
 	}
 }
 
@@ -71,33 +110,72 @@ func (b *BPF) Load(uprobes []uprobe.Uprobe, opts LoadOptions) (err error) {
 		return err
 	}
 
+	b.useRingbuf = features.HaveMapType(ebpf.RingBuf) == nil
+	if !b.useRingbuf && !opts.Stat {
+		log.Debug("ringbuf is not supported, fall back to perf event array")
+		// ringbuf maps are not referenced on the perf path, but they
+		// still have to be created for the programs to load.
+		spec.Maps["event_ringbuf"] = &ebpf.MapSpec{
+			Name:       "event_ringbuf",
+			Type:       ebpf.Array,
+			KeySize:    4,
+			ValueSize:  4,
+			MaxEntries: 1,
+		}
+	}
+
+	b.useCookie = features.HaveProgramHelper(ebpf.Kprobe, asm.FnGetAttachCookie) == nil
+	if opts.PIE && !b.useCookie && opts.Pid == 0 {
+		return errors.New("tracing PIE binary requires --pid on kernels without attach cookies")
+	}
+
+	kernelTypes, useR14, err := kernelTypes(opts.RegABI)
+	if err != nil {
+		return
+	}
+	b.useR14 = useR14
+
+	// Kernels without uprobe_multi don't check the expected attach type of
+	// kprobe programs, so the same programs can be attached by both ways.
+	for _, prog := range spec.Programs {
+		prog.AttachType = ebpf.AttachTraceUprobeMulti
+	}
+
 	b.objs = &GofuncgraphObjects{}
 	defer func() {
 		if err != nil {
 			return
 		}
-		b.closers = append(b.closers, b.objs.EventQueue)
-		b.closers = append(b.closers, b.objs.EventStack)
+		b.closers = append(b.closers, b.objs)
 	}()
 
 	fetchArgs := false
 	for _, uprobe := range uprobes {
-		if len(uprobe.FetchArgs) > 0 {
+		if len(uprobe.FetchArgs) > 0 && !opts.Stat {
 			fetchArgs = true
 			break
 		}
 	}
-	if err = spec.RewriteConstants(map[string]interface{}{"CONFIG": b.BpfConfig(fetchArgs, opts.GoidOffset, opts.GOffset)}); err != nil {
+	if err = spec.RewriteConstants(map[string]interface{}{"CONFIG": b.BpfConfig(fetchArgs, opts)}); err != nil {
 		return
 	}
-	if err = spec.LoadAndAssign(b.objs, &ebpf.CollectionOptions{
-		Programs: ebpf.ProgramOptions{LogSize: ebpf.DefaultVerifierLogSize * 4},
-	}); err != nil {
+	collOpts := &ebpf.CollectionOptions{
+		Programs: ebpf.ProgramOptions{
+			LogSize:     ebpf.DefaultVerifierLogSize * 4,
+			KernelTypes: kernelTypes,
+		},
+	}
+	if opts.Stat {
+		err = b.loadStat(spec, collOpts)
+	} else {
+		err = spec.LoadAndAssign(b.objs, collOpts)
+	}
+	if err != nil {
 		return
 	}
 
 	for _, uprobe := range uprobes {
-		if len(uprobe.FetchArgs) > 0 {
+		if fetchArgs && len(uprobe.FetchArgs) > 0 {
 			if err = b.setArgRules(uprobe.Address, uprobe.FetchArgs); err != nil {
 				return
 			}
@@ -111,31 +189,64 @@ func (b *BPF) Load(uprobes []uprobe.Uprobe, opts LoadOptions) (err error) {
 	return
 }
 
+// loadStat loads only the programs and maps of stat mode, which has no event
+// transport.
+func (b *BPF) loadStat(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) (err error) {
+	objs := struct {
+		StatEnt        *ebpf.Program `ebpf:"stat_ent"`
+		StatRet        *ebpf.Program `ebpf:"stat_ret"`
+		FuncStats      *ebpf.Map     `ebpf:"func_stats"`
+		ShouldTraceRip *ebpf.Map     `ebpf:"should_trace_rip"`
+	}{}
+	if err = spec.LoadAndAssign(&objs, opts); err != nil {
+		return
+	}
+	b.objs.StatEnt, b.objs.StatRet = objs.StatEnt, objs.StatRet
+	b.objs.FuncStats, b.objs.ShouldTraceRip = objs.FuncStats, objs.ShouldTraceRip
+	b.stat = true
+	return
+}
+
 func (b *BPF) setArgRules(pc uint64, fetchArgs []*uprobe.FetchArg) (err error) {
-	if len(fetchArgs) > 8 {
-		return fmt.Errorf("too many fetch args: %d > 8", len(fetchArgs))
-	}
-	argRules := GofuncgraphArgRules{Length: uint8(len(fetchArgs))}
-	for idx, fetchArg := range fetchArgs {
-		if len(fetchArg.Rules) > 8 {
-			return fmt.Errorf("too many rules: %d > 8", len(fetchArg.Rules))
-		}
-		rule := GofuncgraphArgRule{
-			Type:   uint8(fetchArg.Rules[len(fetchArg.Rules)-1].From),
-			Reg:    RegisterConstants[fetchArg.Rules[0].Register],
-			Size:   uint8(fetchArg.Size),
-			Length: uint8(len(fetchArg.Rules) - 1),
-		}
-
-		j := 0
-		for _, r := range fetchArg.Rules {
-			if r.From == uprobe.Stack {
-				rule.Offsets[j] = int16(r.Offset)
-				j++
+	argRules := GofuncgraphArgRules{}
+	for _, fetchArg := range fetchArgs {
+		if fetchArg.DataOffset+fetchArg.Size > MaxPayloadSize {
+			return fmt.Errorf("fetch args too large: %s at offset %d", fetchArg.Varname, fetchArg.DataOffset)
+		}
+		if int(argRules.Length)+len(fetchArg.Fetches) > 8 {
+			return fmt.Errorf("too many fetches: %s takes %d fetches after %d of the args before it, exceeding 8", fetchArg.Varname, len(fetchArg.Fetches), argRules.Length)
+		}
+		for _, fetch := range fetchArg.Fetches {
+			if len(fetch.Rules) > 8 {
+				return fmt.Errorf("too many rules: %d > 8", len(fetch.Rules))
+			}
+			if fetch.Size > MaxDataSize {
+				return fmt.Errorf("fetch args too large: %s of size %d", fetchArg.Varname, fetch.Size)
+			}
+			rule := GofuncgraphArgRule{
+				Type:       uint8(fetch.Rules[len(fetch.Rules)-1].From),
+				Reg:        RegisterConstants[fetch.Rules[0].Register],
+				Size:       uint8(fetch.Size),
+				Length:     uint8(len(fetch.Rules) - 1),
+				DataOffset: uint16(fetchArg.DataOffset + fetch.Offset),
+			}
+			if fetch.ElemSize > 0 {
+				rule.Type = 2
+				rule.LenOffset = uint16(fetchArg.DataOffset + fetch.LenOffset)
+				rule.ElemSize = uint8(fetch.ElemSize)
 			}
+
+			j := 0
+			for _, r := range fetch.Rules {
+				if r.From == uprobe.Stack {
+					rule.Offsets[j] = int16(r.Offset)
+					j++
+				}
+			}
+			argRules.Rules[argRules.Length] = rule
+			argRules.Length++
+			log.Debugf("add arg rule at %x: %+v", pc, rule)
 		}
-		argRules.Rules[idx] = rule
-		fmt.Printf("add arg rule at %x: %+v\n", pc, rule)
 	}
 	return b.objs.ArgRulesMap.Update(pc, argRules, ebpf.UpdateNoExist)
 }
@@ -149,18 +260,22 @@ func (b *BPF) Attach(bin string, uprobes []uprobe.Uprobe) (err error) {
 	if err != nil {
 		return
 	}
+
+	if err = b.attachMulti(ex, uprobes); err == nil {
+		return
+	}
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		return
+	}
+	log.Debug("uprobe_multi link is not supported, fall back to attaching uprobes one by one")
+
 	for i, up := range uprobes {
-		var prog *ebpf.Program
-		switch up.Location {
-		case uprobe.AtEntry:
-			prog = b.objs.Ent
-		case uprobe.AtRet:
-			prog = b.objs.Ret
-		case uprobe.AtGoroutineExit:
-			prog = b.objs.GoroutineExit
-		}
 		fmt.Printf("attaching %d/%d\r", i+1, len(uprobes))
-		up, err := ex.Uprobe("", prog, &link.UprobeOptions{Offset: up.AbsOffset})
+		opts := &link.UprobeOptions{Address: up.AbsOffset}
+		if b.useCookie {
+			opts.Cookie = up.Address
+		}
+		up, err := ex.Uprobe("", b.program(up.Location), opts)
 		if err != nil {
 			return err
 		}
@@ -170,6 +285,74 @@ func (b *BPF) Attach(bin string, uprobes []uprobe.Uprobe) (err error) {
 	return
 }
 
+// attachMulti attaches all offsets of a program by one uprobe_multi link.
+func (b *BPF) attachMulti(ex *link.Executable, uprobes []uprobe.Uprobe) (err error) {
+	offsets := map[uprobe.UprobeLocation][]uint64{}
+	cookies := map[uprobe.UprobeLocation][]uint64{}
+	for _, up := range uprobes {
+		offsets[up.Location] = append(offsets[up.Location], up.AbsOffset)
+		cookies[up.Location] = append(cookies[up.Location], up.Address)
+	}
+
+	links := []io.Closer{}
+	defer func() {
+		if err != nil {
+			for _, l := range links {
+				l.Close()
+			}
+		}
+	}()
+	for location, addrs := range offsets {
+		opts := &link.UprobeMultiOptions{Addresses: addrs}
+		if b.useCookie {
+			opts.Cookies = cookies[location]
+		}
+		l, err := ex.UprobeMulti(nil, b.program(location), opts)
+		if err != nil {
+			return err
+		}
+		links = append(links, l)
+	}
+	b.closers = append(b.closers, links...)
+	return
+}
+
+func (b *BPF) program(location uprobe.UprobeLocation) *ebpf.Program {
+	switch location {
+	case uprobe.AtEntry:
+		if b.stat {
+			return b.objs.StatEnt
+		}
+		return b.objs.Ent
+	case uprobe.AtRet:
+		if b.stat {
+			return b.objs.StatRet
+		}
+		return b.objs.Ret
+	case uprobe.AtGoroutineExit:
+		return b.objs.GoroutineExit
+	case uprobe.AtNewproc:
+		return b.objs.Newproc
+	case uprobe.AtNewprocRet:
+		return b.objs.NewprocRet
+	case uprobe.AtGopark:
+		return b.objs.Gopark
+	case uprobe.AtGoparkRet:
+		return b.objs.GoparkRet
+	case uprobe.AtGoroutineReady:
+		return b.objs.GoroutineReady
+	case uprobe.AtPanic:
+		return b.objs.Gopanic
+	case uprobe.AtRecover:
+		return b.objs.Gorecover
+	case uprobe.AtRecovery:
+		return b.objs.Recovery
+	case uprobe.AtCopystack:
+		return b.objs.Copystack
+	}
+	return nil
+}
+
 func (b *BPF) Detach() {
 	log.Info("start detaching\n")
 	sem := semaphore.NewWeighted(10)
@@ -184,46 +367,66 @@ func (b *BPF) Detach() {
 	fmt.Println()
 }
 
-func (b *BPF) PollEvents(ctx context.Context) chan GofuncgraphEvent {
-	ch := make(chan GofuncgraphEvent)
+// LostEvents returns the number of events failed to submit to userspace,
+// indexed by probe address and then cpu.
+func (b *BPF) LostEvents() (lost map[uint64][]uint64, err error) {
+	lost = map[uint64][]uint64{}
+	var (
+		ip     uint64
+		counts []uint64
+	)
+	iter := b.objs.LostEvents.Iterate()
+	for iter.Next(&ip, &counts) {
+		lost[ip] = append([]uint64{}, counts...)
+	}
+	return lost, iter.Err()
+}
 
-	go func() {
-		defer close(ch)
-		for {
-			event := GofuncgraphEvent{}
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if err := b.objs.EventQueue.LookupAndDelete(nil, &event); err != nil {
-					time.Sleep(time.Millisecond)
-					continue
-				}
-				ch <- event
-			}
-		}
-	}()
-	return ch
+// FuncStats returns the latency histograms accumulated in stat mode, indexed
+// by function entry address.
+func (b *BPF) FuncStats() (stats map[uint64]GofuncgraphFuncStat, err error) {
+	stats = map[uint64]GofuncgraphFuncStat{}
+	var (
+		ip   uint64
+		stat GofuncgraphFuncStat
+	)
+	iter := b.objs.FuncStats.Iterate()
+	for iter.Next(&ip, &stat) {
+		stats[ip] = stat
+	}
+	return stats, iter.Err()
 }
 
-func (b *BPF) PollArg(ctx context.Context) <-chan GofuncgraphArgData {
-	ch := make(chan GofuncgraphArgData)
+func (b *BPF) PollEvents(ctx context.Context) (_ <-chan GofuncgraphEvent, err error) {
+	reader, err := b.newReader(b.objs.EventRingbuf, b.objs.EventPerfArray)
+	if err != nil {
+		return
+	}
+	ch := make(chan GofuncgraphEvent)
+	go func() {
+		<-ctx.Done()
+		reader.Close()
+	}()
 	go func() {
 		defer close(ch)
 		for {
-			data := GofuncgraphArgData{}
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if err := b.objs.ArgQueue.LookupAndDelete(nil, &data); err != nil {
-					time.Sleep(time.Millisecond)
-					continue
+			raw, err := reader.Read()
+			if err != nil {
+				if err != errReaderClosed {
+					log.Errorf("failed to read event: %+v", err)
 				}
-				ch <- data
+				return
 			}
-
+			// payload is truncated to its actual length by bpf
+			buf := make([]byte, eventSize)
+			copy(buf, raw)
+			event := GofuncgraphEvent{}
+			if err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &event); err != nil {
+				log.Errorf("failed to decode event: %+v", err)
+				continue
+			}
+			ch <- event
 		}
 	}()
-	return ch
+	return ch, nil
 }
@@ -0,0 +1,42 @@
+package bpf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestBpfConfigLayout(t *testing.T) {
+	spec, err := LoadGofuncgraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config *btf.Struct
+	for _, v := range spec.Maps[".rodata"].Value.(*btf.Datasec).Vars {
+		if v.Type.TypeName() == "CONFIG" {
+			config = btf.UnderlyingType(v.Type.(*btf.Var).Type).(*btf.Struct)
+		}
+	}
+	if config == nil {
+		t.Fatal("CONFIG not found in .rodata")
+	}
+
+	typ := reflect.TypeOf(New().BpfConfig(false, LoadOptions{}))
+	if typ.Size() != uintptr(config.Size) {
+		t.Fatalf("size of config: got %d, want %d", typ.Size(), config.Size)
+	}
+	if typ.NumField() != len(config.Members) {
+		t.Fatalf("fields of config: got %d, want %d", typ.NumField(), len(config.Members))
+	}
+	for i, member := range config.Members {
+		field := typ.Field(i)
+		if name := strings.ReplaceAll(member.Name, "_", ""); !strings.EqualFold(field.Name, name) {
+			t.Errorf("field %d: got %s, want %s", i, field.Name, member.Name)
+		}
+		if field.Offset != uintptr(member.Offset.Bytes()) {
+			t.Errorf("offset of %s: got %d, want %d", member.Name, field.Offset, member.Offset.Bytes())
+		}
+	}
+}
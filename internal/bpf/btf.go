@@ -0,0 +1,57 @@
+package bpf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+	log "github.com/sirupsen/logrus"
+)
+
+// kernelTypes returns the kernel BTF to relocate the offset of fsbase in
+// task_struct. Without it, g is read from r14 if the binary is built with the
+// register ABI, and the CO-RE relocations are poisoned against empty types
+// on the dead path.
+func kernelTypes(regABI bool) (spec *btf.Spec, useR14 bool, err error) {
+	spec, err = btf.LoadKernelSpec()
+	if err == nil {
+		if err = checkFsbase(spec); err == nil {
+			return spec, false, nil
+		}
+	}
+	if !regABI {
+		return nil, false, fmt.Errorf("unable to read g: no fsbase in kernel BTF (%w), and no g in r14 without register ABI", err)
+	}
+	log.Debugf("read g from r14: %+v", err)
+
+	var b btf.Builder
+	raw, err := b.Marshal(nil, nil)
+	if err != nil {
+		return
+	}
+	spec, err = btf.LoadSpecFromReader(bytes.NewReader(raw))
+	return spec, true, err
+}
+
+func checkFsbase(spec *btf.Spec) (err error) {
+	var task *btf.Struct
+	if err = spec.TypeByName("task_struct", &task); err != nil {
+		return
+	}
+	for _, member := range task.Members {
+		if member.Name != "thread" {
+			continue
+		}
+		thread, ok := btf.UnderlyingType(member.Type).(*btf.Struct)
+		if !ok {
+			break
+		}
+		for _, member := range thread.Members {
+			if member.Name == "fsbase" {
+				return nil
+			}
+		}
+	}
+	return errors.New("task_struct.thread.fsbase not found")
+}
@@ -4,24 +4,46 @@
 #include "bpf_helpers.h"
 
 #define MAX_DATA_SIZE 64
+#define MAX_PAYLOAD_SIZE 512
 
 #define ENTPOINT 0
 #define RETPOINT 1
+#define SPAWNPOINT 2
+#define PARKPOINT 3
+#define PANICPOINT 4
+#define RECOVERPOINT 5
+#define EXITPOINT 6
+#define STACKPOINT 7
 
-#define fsbase_off (offsetof(struct task_struct, thread) \
-		    + offsetof(struct thread_struct, fsbase))
+#define MAX_PANIC_MSG_SIZE 256
+
+// offsets of g.stack.lo and g.stack.hi, see runtime.g
+#define G_STACK_LO_OFFSET 0
+#define G_STACK_HI_OFFSET 8
 
 char __license[] SEC("license") = "Dual MIT/GPL";
 
 struct config {
 	__s64 goid_offset;
 	__s64 g_offset;
+	__u32 pid;
 	bool fetch_args;
-	__u8 padding[7];
+	bool use_ringbuf;
+	bool use_r14;
+	bool use_cookie;
+	// registers of callergp and callerpc passed to runtime.newproc1
+	__u8 newproc_gp_reg;
+	__u8 newproc_pc_reg;
+	__u8 padding[6];
+	__u64 load_bias;
+	// root frames returning faster than threshold_ns are dropped
+	__u64 threshold_ns;
 };
 
 static volatile const struct config CONFIG = {};
 
+// bp and caller_bp are offsets from the top of the goroutine stack, which
+// are kept when the stack is copied to grow or shrink.
 struct event {
 	__u64 goid;
 	__u64 ip;
@@ -30,6 +52,14 @@ struct event {
 	__u64 caller_bp;
 	__u64 time_ns;
 	__u8 location;
+	__u8 padding;
+	__u16 payload_len;
+	__u32 lost;
+	__u64 root_ns;
+	// load_bias of the process at ENTPOINT and RETPOINT, by which the
+	// addresses fetched in args are symbolized
+	__u64 load_bias;
+	__u8 payload[MAX_PAYLOAD_SIZE + MAX_DATA_SIZE];
 };
 
 // force emitting struct event into the ELF.
@@ -40,7 +70,13 @@ struct arg_rule {
 	__u8 reg;
 	__u8 size;
 	__u8 length;
+	__u16 data_offset;
 	__s16 offsets[8];
+	// for type 2, the size read is limited by the length fetched at
+	// len_offset times elem_size, such as the bytes of a string
+	__u16 len_offset;
+	__u8 elem_size;
+	__u8 padding;
 };
 
 struct arg_rules {
@@ -50,12 +86,51 @@ struct arg_rules {
 
 const struct arg_rules *__ __attribute__((unused));
 
-struct arg_data {
+struct stat_key {
 	__u64 goid;
-	__u8 data[MAX_DATA_SIZE];
+	__u64 bp;
+};
+
+struct stat_start {
+	__u64 ip;
+	__u64 time_ns;
+};
+
+#define MAX_SLOTS 64
+
+struct func_stat {
+	__u64 count;
+	__u64 total_ns;
+	__u64 slots[MAX_SLOTS];
 };
 
-const struct arg_data *___ __attribute__((unused));
+const struct func_stat *____ __attribute__((unused));
+
+// zero_stat initializes the stats of a function, which don't fit in the stack
+static const struct func_stat zero_stat = {};
+
+struct bpf_map_def SEC("maps") stat_starts = {
+	.type = BPF_MAP_TYPE_LRU_HASH,
+	.key_size = sizeof(struct stat_key),
+	.value_size = sizeof(struct stat_start),
+	.max_entries = 100000,
+};
+
+// stat_roots holds the bp of the outermost wanted frame of each goroutine,
+// below which the frames are counted in stat mode.
+struct bpf_map_def SEC("maps") stat_roots = {
+	.type = BPF_MAP_TYPE_LRU_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(__u64),
+	.max_entries = 10000,
+};
+
+struct bpf_map_def SEC("maps") func_stats = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(struct func_stat),
+	.max_entries = 10000,
+};
 
 struct bpf_map_def SEC("maps") arg_rules_map = {
 	.type = BPF_MAP_TYPE_HASH,
@@ -64,32 +139,116 @@ struct bpf_map_def SEC("maps") arg_rules_map = {
 	.max_entries = 100,
 };
 
-struct bpf_map_def SEC("maps") arg_queue = {
-	.type = BPF_MAP_TYPE_QUEUE,
-	.key_size = 0,
-	.value_size = sizeof(struct arg_data),
-	.max_entries = 10000,
+struct bpf_map_def SEC("maps") event_ringbuf = {
+	.type = BPF_MAP_TYPE_RINGBUF,
+	.max_entries = 1 << 24,
+};
+
+struct bpf_map_def SEC("maps") event_perf_array = {
+	.type = BPF_MAP_TYPE_PERF_EVENT_ARRAY,
+	.key_size = sizeof(__u32),
+	.value_size = sizeof(__u32),
 };
 
-struct bpf_map_def SEC("maps") arg_stack = {
+struct bpf_map_def SEC("maps") event_stack = {
 	.type = BPF_MAP_TYPE_PERCPU_ARRAY,
 	.key_size = sizeof(__u32),
-	.value_size = sizeof(struct arg_data),
+	.value_size = sizeof(struct event),
 	.max_entries = 1,
 };
 
-struct bpf_map_def SEC("maps") event_queue = {
-	.type = BPF_MAP_TYPE_QUEUE,
-	.key_size = 0,
-	.value_size = sizeof(struct event),
+struct spawn {
+	__u64 parent_goid;
+	__u64 pc;
+};
+
+// payload of SPAWNPOINT event
+struct spawn_data {
+	__u64 goid;
+};
+
+const struct spawn_data *_____ __attribute__((unused));
+
+struct bpf_map_def SEC("maps") spawns = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(struct spawn),
 	.max_entries = 10000,
 };
 
-struct bpf_map_def SEC("maps") event_stack = {
-	.type = BPF_MAP_TYPE_PERCPU_ARRAY,
-	.key_size = sizeof(__u32),
+struct park {
+	__u64 park_ns;
+	__u64 ready_ns;
+	__u8 reason;
+	__u8 padding[7];
+};
+
+// payload of PARKPOINT event
+struct park_data {
+	__u64 runnable_ns;
+	__u64 blocked_ns;
+	__u8 reason;
+	__u8 padding[7];
+};
+
+const struct park_data *______ __attribute__((unused));
+
+struct bpf_map_def SEC("maps") parks = {
+	.type = BPF_MAP_TYPE_LRU_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(struct park),
+	.max_entries = 10000,
+};
+
+// payload of PANICPOINT event
+struct panic_data {
+	__u64 type;
+	__u64 msg_len;
+	__u8 msg[MAX_PANIC_MSG_SIZE];
+};
+
+const struct panic_data *_______ __attribute__((unused));
+
+struct goid_depth {
+	__u64 depth;
+	__u64 root_start_ns;
+	__u64 root_bp;
+	__u64 recover_pc;
+	__u64 last_ip;
+	__u64 last_bp;
+	bool recovering;
+	__u8 padding[7];
+};
+
+// root_entries defers the ENTPOINT of root frames when there is a threshold,
+// which is submitted before any other event of the goroutine, or dropped
+// together with the RETPOINT if the root returns faster than the threshold.
+struct bpf_map_def SEC("maps") root_entries = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
 	.value_size = sizeof(struct event),
-	.max_entries = 1,
+	.max_entries = 10000,
+};
+
+struct bpf_map_def SEC("maps") goid_depths = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(struct goid_depth),
+	.max_entries = 10000,
+};
+
+struct bpf_map_def SEC("maps") lost_events = {
+	.type = BPF_MAP_TYPE_PERCPU_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(__u64),
+	.max_entries = 100000,
+};
+
+struct bpf_map_def SEC("maps") goid_lost_events = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(__u32),
+	.max_entries = 10000,
 };
 
 struct bpf_map_def SEC("maps") should_trace_goid = {
@@ -106,17 +265,72 @@ struct bpf_map_def SEC("maps") should_trace_rip = {
 	.max_entries = 10000,
 };
 
+// get_g reads the current g from the TLS at fsbase, whose offset in
+// task_struct is relocated by CO-RE, or from r14 where ABIInternal code keeps
+// g when there is no kernel BTF.
 static __always_inline
-__u64 get_goid()
+__u64 get_g(struct pt_regs *ctx)
 {
-	__u64 tls_base, g_addr, goid;
+	if (CONFIG.use_r14)
+		return ctx->r14;
+
+	__u64 tls_base, g_addr;
 	struct task_struct *task = (struct task_struct *)bpf_get_current_task();
-	bpf_probe_read_kernel(&tls_base, sizeof(tls_base), (void *)task + fsbase_off);
+	bpf_probe_read_kernel(&tls_base, sizeof(tls_base),
+			      __builtin_preserve_access_index(&task->thread.fsbase));
 	bpf_probe_read_user(&g_addr, sizeof(g_addr), (void *)(tls_base+CONFIG.g_offset));
+	return g_addr;
+}
+
+static __always_inline
+__u64 read_goid(__u64 g_addr)
+{
+	__u64 goid = 0;
 	bpf_probe_read_user(&goid, sizeof(goid), (void *)(g_addr+CONFIG.goid_offset));
 	return goid;
 }
 
+static __always_inline
+__u64 read_stack_hi(__u64 g_addr)
+{
+	__u64 hi = 0;
+	bpf_probe_read_user(&hi, sizeof(hi), (void *)(g_addr+G_STACK_HI_OFFSET));
+	return hi;
+}
+
+static __always_inline
+__u64 get_goid(struct pt_regs *ctx)
+{
+	return read_goid(get_g(ctx));
+}
+
+// load_bias returns the difference between the runtime and link-time
+// addresses of the binary, which is non-zero for PIE. The link-time address
+// of every probe is attached as the cookie if the kernel supports.
+static __always_inline
+__u64 load_bias(struct pt_regs *ctx)
+{
+	if (CONFIG.use_cookie)
+		return ctx->ip - bpf_get_attach_cookie(ctx);
+	return CONFIG.load_bias;
+}
+
+static __always_inline
+bool filtered_by_pid()
+{
+	if (!CONFIG.pid)
+		return false;
+	return (bpf_get_current_pid_tgid() >> 32) != CONFIG.pid;
+}
+
+static __always_inline
+long output(struct pt_regs *ctx, void *ringbuf, void *perf_array, void *data, __u64 size)
+{
+	if (CONFIG.use_ringbuf)
+		return bpf_ringbuf_output(ringbuf, data, size, 0);
+	return bpf_perf_event_output(ctx, perf_array, BPF_F_CURRENT_CPU, data, size);
+}
+
 static __always_inline
 void read_reg(struct pt_regs *ctx, __u8 reg, __u64 *regval)
 {
@@ -174,15 +388,20 @@ void read_reg(struct pt_regs *ctx, __u8 reg, __u64 *regval)
 }
 
 static __always_inline
-void fetch_args_from_reg(struct pt_regs *ctx, struct arg_data *data, struct arg_rule *rule)
+void *payload_of(struct event *e, struct arg_rule *rule)
+{
+	return &e->payload[rule->data_offset & (MAX_PAYLOAD_SIZE - 1)];
+}
+
+static __always_inline
+void fetch_args_from_reg(struct pt_regs *ctx, struct event *e, struct arg_rule *rule)
 {
-	read_reg(ctx, rule->reg, (__u64 *)&data->data);
-	bpf_map_push_elem(&arg_queue, data, BPF_EXIST);
+	read_reg(ctx, rule->reg, payload_of(e, rule));
 	return;
 }
 
 static __always_inline
-void fetch_args_from_memory(struct pt_regs *ctx, struct arg_data *data, struct arg_rule *rule)
+void fetch_args_from_memory(struct pt_regs *ctx, struct event *e, struct arg_rule *rule)
 {
 	__u64 addr = 0;
 	read_reg(ctx, rule->reg, &addr);
@@ -192,53 +411,217 @@ void fetch_args_from_memory(struct pt_regs *ctx, struct arg_data *data, struct a
 			break;
 		bpf_probe_read_user(&addr, sizeof(addr), (void *)addr+rule->offsets[i]);
 	}
-	bpf_probe_read_user(&data->data,
-			    rule->size < MAX_DATA_SIZE ? rule->size : MAX_DATA_SIZE,
-			    (void *)addr);
-	bpf_map_push_elem(&arg_queue, data, BPF_EXIST);
+	addr += rule->offsets[(rule->length - 1) & 7];
+	__u64 size = rule->size < MAX_DATA_SIZE ? rule->size : MAX_DATA_SIZE;
+	if (rule->type == 2) {
+		__u64 len = 0;
+		__builtin_memcpy(&len, &e->payload[rule->len_offset & (MAX_PAYLOAD_SIZE - 1)], sizeof(len));
+		len *= rule->elem_size;
+		if (len < size)
+			size = len;
+	}
+	if (size > 0)
+		bpf_probe_read_user(payload_of(e, rule), size, (void *)addr);
 	return;
 }
 
 static __always_inline
-void fetch_args(struct pt_regs *ctx, __u64 goid, __u64 ip)
+void fetch_args(struct pt_regs *ctx, struct event *e)
 {
-	struct arg_rules *rules = bpf_map_lookup_elem(&arg_rules_map, &ip);
+	struct arg_rules *rules = bpf_map_lookup_elem(&arg_rules_map, &e->ip);
 	if (!rules)
 		return;
 
-	__u32 key = 0;
-	struct arg_data *data = bpf_map_lookup_elem(&arg_stack, &key);
-	if (!data)
-		return;
-
-	__builtin_memset(data, 0, sizeof(*data));
-	data->goid = goid;
-
 	for (int i = 0; i < 8; i++) {
 		if (rules->length == i)
 			break;
 		switch (rules->rules[i].type) {
 		case 0:
-			fetch_args_from_reg(ctx, data, &rules->rules[i]);
+			fetch_args_from_reg(ctx, e, &rules->rules[i]);
 			break;
 		case 1:
-			fetch_args_from_memory(ctx, data, &rules->rules[i]);
+		case 2:
+			fetch_args_from_memory(ctx, e, &rules->rules[i]);
 			break;
 		}
+		__u16 end = rules->rules[i].data_offset + rules->rules[i].size;
+		if (end > e->payload_len)
+			e->payload_len = end;
 	}
 }
 
+static __always_inline
+__u64 event_size(struct event *e)
+{
+	__u64 len = e->payload_len;
+	if (len > sizeof(e->payload))
+		len = sizeof(e->payload);
+	return offsetof(struct event, payload) + len;
+}
+
+static __always_inline
+void submit_event(struct pt_regs *ctx, struct event *e)
+{
+	__u32 *goid_lost = bpf_map_lookup_elem(&goid_lost_events, &e->goid);
+	if (goid_lost)
+		e->lost = *goid_lost;
+
+	if (!output(ctx, &event_ringbuf, &event_perf_array, e, event_size(e))) {
+		if (goid_lost)
+			bpf_map_delete_elem(&goid_lost_events, &e->goid);
+		return;
+	}
+
+	__u64 *lost = bpf_map_lookup_elem(&lost_events, &e->ip);
+	if (lost) {
+		(*lost)++;
+	} else {
+		__u64 one = 1;
+		bpf_map_update_elem(&lost_events, &e->ip, &one, BPF_NOEXIST);
+	}
+
+	if (goid_lost) {
+		__sync_fetch_and_add(goid_lost, 1);
+	} else {
+		__u32 one = 1;
+		bpf_map_update_elem(&goid_lost_events, &e->goid, &one, BPF_NOEXIST);
+	}
+}
+
+// submit submits the deferred root of the goroutine ahead of the event, as
+// the tree turns out to have more than the root.
+static __always_inline
+void submit(struct pt_regs *ctx, struct event *e)
+{
+	if (CONFIG.threshold_ns) {
+		struct event *root = bpf_map_lookup_elem(&root_entries, &e->goid);
+		if (root) {
+			submit_event(ctx, root);
+			bpf_map_delete_elem(&root_entries, &e->goid);
+		}
+	}
+	submit_event(ctx, e);
+}
+
+// defer_root keeps the ENTPOINT of the root frame in root_entries instead of
+// submitting it when there is a threshold.
+static __always_inline
+bool defer_root(struct event *e)
+{
+	if (!CONFIG.threshold_ns)
+		return false;
+	return !bpf_map_update_elem(&root_entries, &e->goid, e, BPF_ANY);
+}
+
+static __always_inline
+__u32 log2l(__u64 v)
+{
+	__u32 r = 0;
+	if (v >> 32) { v >>= 32; r += 32; }
+	if (v >> 16) { v >>= 16; r += 16; }
+	if (v >> 8) { v >>= 8; r += 8; }
+	if (v >> 4) { v >>= 4; r += 4; }
+	if (v >> 2) { v >>= 2; r += 2; }
+	if (v >> 1) { r += 1; }
+	return r;
+}
+
+static __always_inline
+void stat_enter(__u64 goid, __u64 bp, __u64 ip)
+{
+	struct stat_key key = {.goid = goid, .bp = bp};
+	struct stat_start start = {.ip = ip, .time_ns = bpf_ktime_get_ns()};
+	bpf_map_update_elem(&stat_starts, &key, &start, BPF_ANY);
+}
+
+static __always_inline
+void stat_exit(__u64 goid, __u64 bp)
+{
+	struct stat_key key = {.goid = goid, .bp = bp};
+	struct stat_start *start = bpf_map_lookup_elem(&stat_starts, &key);
+	if (!start)
+		return;
+
+	__u64 ip = start->ip;
+	__u64 delta = bpf_ktime_get_ns() - start->time_ns;
+	bpf_map_delete_elem(&stat_starts, &key);
+
+	struct func_stat *stat = bpf_map_lookup_elem(&func_stats, &ip);
+	if (!stat) {
+		bpf_map_update_elem(&func_stats, &ip, &zero_stat, BPF_NOEXIST);
+		stat = bpf_map_lookup_elem(&func_stats, &ip);
+		if (!stat)
+			return;
+	}
+	__sync_fetch_and_add(&stat->count, 1);
+	__sync_fetch_and_add(&stat->total_ns, delta);
+	__sync_fetch_and_add(&stat->slots[log2l(delta) & (MAX_SLOTS - 1)], 1);
+}
+
+// enter_frame returns false for the entry executed again by the prologue of
+// the function, and sets new_root for a new tree.
+static __always_inline
+bool enter_frame(struct event *e, bool *new_root)
+{
+	struct goid_depth *depth = bpf_map_lookup_elem(&goid_depths, &e->goid);
+	// after a recovered panic, a call from a frame above the root means
+	// the whole tree was unwound.
+	if (!depth || (depth->recovering && e->caller_bp < depth->root_bp)) {
+		struct goid_depth root = {
+			.depth = 1,
+			.root_start_ns = e->time_ns,
+			.root_bp = e->bp,
+			.last_ip = e->ip,
+			.last_bp = e->bp,
+		};
+		bpf_map_update_elem(&goid_depths, &e->goid, &root, BPF_ANY);
+		*new_root = true;
+		return true;
+	}
+	// the prologue runs the entry again after growing the stack or being
+	// preempted, with no frame entered or returned in between
+	if (depth->last_ip == e->ip && depth->last_bp == e->bp && !depth->recovering)
+		return false;
+	depth->recovering = false;
+	depth->last_ip = e->ip;
+	depth->last_bp = e->bp;
+	depth->depth++;
+	return true;
+}
+
+// exit_frame sets the elapsed time of the root frame when it returns. The
+// root is also recognized by its bp, as frames unwound by a recovered panic
+// never return.
+static __always_inline
+void exit_frame(struct event *e)
+{
+	struct goid_depth *depth = bpf_map_lookup_elem(&goid_depths, &e->goid);
+	if (!depth)
+		return;
+	depth->recovering = false;
+	depth->last_ip = 0;
+	if (--depth->depth > 0 && e->bp != depth->root_bp)
+		return;
+	e->root_ns = e->time_ns - depth->root_start_ns;
+	bpf_map_delete_elem(&goid_depths, &e->goid);
+}
+
 SEC("uprobe/ent")
 int ent(struct pt_regs *ctx)
 {
+	if (filtered_by_pid())
+		return 0;
+
 	__u32 key = 0;
 	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
 	if (!e)
 		return 0;
 	__builtin_memset(e, 0, sizeof(*e));
 
-	e->goid = get_goid();
-	e->ip = ctx->ip;
+	__u64 g_addr = get_g(ctx);
+	e->goid = read_goid(g_addr);
+	e->load_bias = load_bias(ctx);
+	e->ip = ctx->ip - e->load_bias;
 	if (!bpf_map_lookup_elem(&should_trace_rip, &e->ip)) {
 		if (!bpf_map_lookup_elem(&should_trace_goid, &e->goid))
 			return 0;
@@ -251,46 +634,400 @@ int ent(struct pt_regs *ctx)
 
 	e->location = ENTPOINT;
 	e->time_ns = bpf_ktime_get_ns();
-	e->bp = ctx->sp - 8;
-	e->caller_bp = ctx->bp;
+	__u64 stack_hi = read_stack_hi(g_addr);
+	e->bp = stack_hi - (ctx->sp - 8);
+	e->caller_bp = stack_hi - ctx->bp;
 
 	void *ra;
 	ra = (void*)ctx->sp;
 	bpf_probe_read_user(&e->caller_ip, sizeof(e->caller_ip), ra);
+	e->caller_ip -= ctx->ip - e->ip;
+
+	bool root = false;
+	if (!enter_frame(e, &root))
+		return 0;
 
 	if (!CONFIG.fetch_args)
 		goto cont;
 
-	fetch_args(ctx, e->goid, e->ip);
+	fetch_args(ctx, e);
 
 cont:
-	return bpf_map_push_elem(&event_queue, e, BPF_EXIST);
+	if (root && defer_root(e))
+		return 0;
+	submit(ctx, e);
+	return 0;
 }
 
 SEC("uprobe/ret")
 int ret(struct pt_regs *ctx)
 {
+	if (filtered_by_pid())
+		return 0;
+
 	__u32 key = 0;
 	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
 	if (!e)
 		return 0;
 	__builtin_memset(e, 0, sizeof(*e));
 
-	e->goid = get_goid();
+	__u64 g_addr = get_g(ctx);
+	e->goid = read_goid(g_addr);
 	if (!bpf_map_lookup_elem(&should_trace_goid, &e->goid))
 		return 0;
 
 	e->location = RETPOINT;
-	e->ip = ctx->ip;
+	e->load_bias = load_bias(ctx);
+	e->ip = ctx->ip - e->load_bias;
 	e->time_ns = bpf_ktime_get_ns();
+	// sp points to the return address at both entry and RET
+	e->bp = read_stack_hi(g_addr) - (ctx->sp - 8);
+
+	exit_frame(e);
+	// a fast root is dropped with its entry if nothing else was submitted
+	if (e->root_ns && e->root_ns < CONFIG.threshold_ns &&
+	    !bpf_map_delete_elem(&root_entries, &e->goid))
+		return 0;
+
+	if (CONFIG.fetch_args)
+		fetch_args(ctx, e);
+
+	submit(ctx, e);
+	return 0;
+}
+
+// stat_ent and stat_ret replace ent and ret in stat mode, which accumulate
+// the latencies of the frames below a wanted frame and submit nothing.
+SEC("uprobe/stat_ent")
+int stat_ent(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 g_addr = get_g(ctx);
+	__u64 goid = read_goid(g_addr);
+	__u64 ip = ctx->ip - load_bias(ctx);
+	__u64 bp = read_stack_hi(g_addr) - (ctx->sp - 8);
+
+	__u64 *root_bp = bpf_map_lookup_elem(&stat_roots, &goid);
+	// a frame not below the root means the root was unwound by a panic
+	if (!root_bp || bp <= *root_bp) {
+		if (!bpf_map_lookup_elem(&should_trace_rip, &ip)) {
+			if (root_bp)
+				bpf_map_delete_elem(&stat_roots, &goid);
+			return 0;
+		}
+		bpf_map_update_elem(&stat_roots, &goid, &bp, BPF_ANY);
+	}
+
+	stat_enter(goid, bp, ip);
+	return 0;
+}
+
+SEC("uprobe/stat_ret")
+int stat_ret(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 g_addr = get_g(ctx);
+	__u64 goid = read_goid(g_addr);
+	__u64 *root_bp = bpf_map_lookup_elem(&stat_roots, &goid);
+	if (!root_bp)
+		return 0;
+
+	// sp points to the return address at both entry and RET
+	__u64 bp = read_stack_hi(g_addr) - (ctx->sp - 8);
+	if (bp == *root_bp)
+		bpf_map_delete_elem(&stat_roots, &goid);
 
-	return bpf_map_push_elem(&event_queue, e, BPF_EXIST);
+	stat_exit(goid, bp);
+	return 0;
 }
 
 SEC("uprobe/goroutine_exit")
 int goroutine_exit(struct pt_regs *ctx)
 {
-	__u64 goid = get_goid();
-	bpf_map_delete_elem(&should_trace_goid, &goid);
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 goid = get_goid(ctx);
+	if (bpf_map_delete_elem(&should_trace_goid, &goid))
+		return 0;
+	bpf_map_delete_elem(&goid_depths, &goid);
+	bpf_map_delete_elem(&parks, &goid);
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		goto out;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = goid;
+	e->location = EXITPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+	submit(ctx, e);
+
+out:
+	// the exit event carries the lost count, and the count is never needed
+	// again even if the event itself is lost
+	bpf_map_delete_elem(&goid_lost_events, &goid);
+	bpf_map_delete_elem(&root_entries, &goid);
+	return 0;
+}
+
+// newproc is attached to the entry of runtime.newproc1, which runs on g0, so
+// the parent goroutine is read from callergp. The registers of callergp and
+// callerpc depend on the signature of newproc1 in the Go version.
+SEC("uprobe/newproc")
+int newproc(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 callergp = 0, callerpc = 0;
+	read_reg(ctx, CONFIG.newproc_gp_reg, &callergp);
+	read_reg(ctx, CONFIG.newproc_pc_reg, &callerpc);
+	__u64 parent_goid = read_goid(callergp);
+	if (!bpf_map_lookup_elem(&goid_depths, &parent_goid))
+		return 0;
+
+	__u64 tid = bpf_get_current_pid_tgid();
+	struct spawn spawn = {.parent_goid = parent_goid, .pc = callerpc - load_bias(ctx)};
+	bpf_map_update_elem(&spawns, &tid, &spawn, BPF_ANY);
+	return 0;
+}
+
+// newproc_ret is attached to the RETs of runtime.newproc1, which returns the
+// new g in ax.
+SEC("uprobe/newproc_ret")
+int newproc_ret(struct pt_regs *ctx)
+{
+	__u64 tid = bpf_get_current_pid_tgid();
+	struct spawn *spawn = bpf_map_lookup_elem(&spawns, &tid);
+	if (!spawn)
+		return 0;
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		return 0;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = spawn->parent_goid;
+	e->caller_ip = spawn->pc;
+	bpf_map_delete_elem(&spawns, &tid);
+
+	struct spawn_data *data = (struct spawn_data *)e->payload;
+	data->goid = read_goid(ctx->ax);
+	__u64 should_trace = true;
+	bpf_map_update_elem(&should_trace_goid, &data->goid, &should_trace, BPF_ANY);
+
+	e->location = SPAWNPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+	e->payload_len = sizeof(*data);
+	submit(ctx, e);
+	return 0;
+}
+
+// gopark is attached to the entry of runtime.gopark(unlockf, lock, reason,
+// traceReason, traceskip), where the goroutine is about to be blocked.
+SEC("uprobe/gopark")
+int gopark(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 goid = get_goid(ctx);
+	if (!bpf_map_lookup_elem(&goid_depths, &goid))
+		return 0;
+
+	struct park park = {
+		.park_ns = bpf_ktime_get_ns(),
+		.reason = ctx->cx,
+	};
+	bpf_map_update_elem(&parks, &goid, &park, BPF_ANY);
+	return 0;
+}
+
+// goroutine_ready is attached to the entry of runtime.ready(gp, traceskip,
+// next), where the parked goroutine becomes runnable. runtime.goready is
+// small enough to be inlined, so it is not probed directly.
+SEC("uprobe/goroutine_ready")
+int goroutine_ready(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 goid = read_goid(ctx->ax);
+	struct park *park = bpf_map_lookup_elem(&parks, &goid);
+	if (park && !park->ready_ns)
+		park->ready_ns = bpf_ktime_get_ns();
+	return 0;
+}
+
+// gopark_ret is attached to the RETs of runtime.gopark, where the goroutine
+// is running again.
+SEC("uprobe/gopark_ret")
+int gopark_ret(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 goid = get_goid(ctx);
+	struct park *park = bpf_map_lookup_elem(&parks, &goid);
+	if (!park)
+		return 0;
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		return 0;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = goid;
+	e->location = PARKPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+
+	// goroutines woken up by netpoller never go through goready
+	__u64 ready_ns = park->ready_ns ? park->ready_ns : e->time_ns;
+	struct park_data *data = (struct park_data *)e->payload;
+	data->runnable_ns = e->time_ns - ready_ns;
+	data->blocked_ns = ready_ns - park->park_ns;
+	data->reason = park->reason;
+	bpf_map_delete_elem(&parks, &goid);
+
+	e->payload_len = sizeof(*data);
+	submit(ctx, e);
+	return 0;
+}
+
+// gopanic is attached to the entry of runtime.gopanic(e any), and reads the
+// message of the panic value assuming a string at the start of the data,
+// which is the case for string and the common error types.
+SEC("uprobe/gopanic")
+int gopanic(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		return 0;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = get_goid(ctx);
+	if (!bpf_map_lookup_elem(&goid_depths, &e->goid))
+		return 0;
+
+	e->location = PANICPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+	bpf_probe_read_user(&e->caller_ip, sizeof(e->caller_ip), (void *)ctx->sp);
+	e->caller_ip -= ctx->ip - e->ip;
+
+	struct panic_data *data = (struct panic_data *)e->payload;
+	data->type = ctx->ax - (ctx->ip - e->ip);
+	__u64 str[2] = {};
+	bpf_probe_read_user(&str, sizeof(str), (void *)ctx->bx);
+	data->msg_len = str[1] < MAX_PANIC_MSG_SIZE ? str[1] : MAX_PANIC_MSG_SIZE - 1;
+	bpf_probe_read_user(&data->msg, data->msg_len & (MAX_PANIC_MSG_SIZE - 1),
+			    (void *)str[0]);
+
+	e->payload_len = sizeof(*data);
+	submit(ctx, e);
+	return 0;
+}
+
+// gorecover is attached to the entry of runtime.gorecover, and remembers the
+// deferred function calling recover().
+SEC("uprobe/gorecover")
+int gorecover(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u64 goid = get_goid(ctx);
+	struct goid_depth *depth = bpf_map_lookup_elem(&goid_depths, &goid);
+	if (!depth)
+		return 0;
+
+	bpf_probe_read_user(&depth->recover_pc, sizeof(depth->recover_pc),
+			    (void *)ctx->sp);
+	depth->recover_pc -= load_bias(ctx);
+	return 0;
+}
+
+// recovery is attached to the entry of runtime.recovery(gp), which runs on
+// g0 and resumes the recovered goroutine in the frame deferring the
+// recovering function.
+SEC("uprobe/recovery")
+int recovery(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		return 0;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = read_goid(ctx->ax);
+	struct goid_depth *depth = bpf_map_lookup_elem(&goid_depths, &e->goid);
+	if (!depth)
+		return 0;
+	depth->recovering = true;
+
+	e->location = RECOVERPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+	e->caller_ip = depth->recover_pc;
+	submit(ctx, e);
+	return 0;
+}
+
+// payload of STACKPOINT event
+struct stack_data {
+	__u64 old_size;
+	__u64 new_size;
+};
+
+const struct stack_data *________ __attribute__((unused));
+
+// copystack is attached to the entry of runtime.copystack(gp, newsize), which
+// runs on g0 when the stack grows in runtime.morestack or shrinks in GC.
+SEC("uprobe/copystack")
+int copystack(struct pt_regs *ctx)
+{
+	if (filtered_by_pid())
+		return 0;
+
+	__u32 key = 0;
+	struct event *e = bpf_map_lookup_elem(&event_stack, &key);
+	if (!e)
+		return 0;
+	__builtin_memset(e, 0, sizeof(*e));
+
+	e->goid = read_goid(ctx->ax);
+	if (!bpf_map_lookup_elem(&goid_depths, &e->goid))
+		return 0;
+
+	e->location = STACKPOINT;
+	e->ip = ctx->ip - load_bias(ctx);
+	e->time_ns = bpf_ktime_get_ns();
+
+	__u64 stack[2] = {};
+	bpf_probe_read_user(&stack, sizeof(stack), (void *)ctx->ax + G_STACK_LO_OFFSET);
+	struct stack_data *data = (struct stack_data *)e->payload;
+	data->old_size = stack[1] - stack[0];
+	data->new_size = ctx->bx;
+
+	e->payload_len = sizeof(*data);
+	submit(ctx, e);
 	return 0;
 }
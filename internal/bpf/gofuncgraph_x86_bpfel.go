@@ -0,0 +1,260 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64
+
+package bpf
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type GofuncgraphArgRule struct {
+	Type       uint8
+	Reg        uint8
+	Size       uint8
+	Length     uint8
+	DataOffset uint16
+	Offsets    [8]int16
+	LenOffset  uint16
+	ElemSize   uint8
+	Padding    uint8
+}
+
+type GofuncgraphArgRules struct {
+	Length uint8
+	_      [1]byte
+	Rules  [8]GofuncgraphArgRule
+}
+
+type GofuncgraphEvent struct {
+	Goid       uint64
+	Ip         uint64
+	Bp         uint64
+	CallerIp   uint64
+	CallerBp   uint64
+	TimeNs     uint64
+	Location   uint8
+	Padding    uint8
+	PayloadLen uint16
+	Lost       uint32
+	RootNs     uint64
+	LoadBias   uint64
+	Payload    [576]uint8
+}
+
+type GofuncgraphFuncStat struct {
+	Count   uint64
+	TotalNs uint64
+	Slots   [64]uint64
+}
+
+type GofuncgraphPanicData struct {
+	Type   uint64
+	MsgLen uint64
+	Msg    [256]uint8
+}
+
+type GofuncgraphParkData struct {
+	RunnableNs uint64
+	BlockedNs  uint64
+	Reason     uint8
+	Padding    [7]uint8
+}
+
+type GofuncgraphSpawnData struct{ Goid uint64 }
+
+type GofuncgraphStackData struct {
+	OldSize uint64
+	NewSize uint64
+}
+
+// LoadGofuncgraph returns the embedded CollectionSpec for Gofuncgraph.
+func LoadGofuncgraph() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_GofuncgraphBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load Gofuncgraph: %w", err)
+	}
+
+	return spec, err
+}
+
+// LoadGofuncgraphObjects loads Gofuncgraph and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*GofuncgraphObjects
+//	*GofuncgraphPrograms
+//	*GofuncgraphMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func LoadGofuncgraphObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := LoadGofuncgraph()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// GofuncgraphSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type GofuncgraphSpecs struct {
+	GofuncgraphProgramSpecs
+	GofuncgraphMapSpecs
+}
+
+// GofuncgraphSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type GofuncgraphProgramSpecs struct {
+	Copystack      *ebpf.ProgramSpec `ebpf:"copystack"`
+	Ent            *ebpf.ProgramSpec `ebpf:"ent"`
+	Gopanic        *ebpf.ProgramSpec `ebpf:"gopanic"`
+	Gopark         *ebpf.ProgramSpec `ebpf:"gopark"`
+	GoparkRet      *ebpf.ProgramSpec `ebpf:"gopark_ret"`
+	Gorecover      *ebpf.ProgramSpec `ebpf:"gorecover"`
+	GoroutineExit  *ebpf.ProgramSpec `ebpf:"goroutine_exit"`
+	GoroutineReady *ebpf.ProgramSpec `ebpf:"goroutine_ready"`
+	Newproc        *ebpf.ProgramSpec `ebpf:"newproc"`
+	NewprocRet     *ebpf.ProgramSpec `ebpf:"newproc_ret"`
+	Recovery       *ebpf.ProgramSpec `ebpf:"recovery"`
+	Ret            *ebpf.ProgramSpec `ebpf:"ret"`
+	StatEnt        *ebpf.ProgramSpec `ebpf:"stat_ent"`
+	StatRet        *ebpf.ProgramSpec `ebpf:"stat_ret"`
+}
+
+// GofuncgraphMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type GofuncgraphMapSpecs struct {
+	ArgRulesMap     *ebpf.MapSpec `ebpf:"arg_rules_map"`
+	EventPerfArray  *ebpf.MapSpec `ebpf:"event_perf_array"`
+	EventRingbuf    *ebpf.MapSpec `ebpf:"event_ringbuf"`
+	EventStack      *ebpf.MapSpec `ebpf:"event_stack"`
+	FuncStats       *ebpf.MapSpec `ebpf:"func_stats"`
+	GoidDepths      *ebpf.MapSpec `ebpf:"goid_depths"`
+	GoidLostEvents  *ebpf.MapSpec `ebpf:"goid_lost_events"`
+	LostEvents      *ebpf.MapSpec `ebpf:"lost_events"`
+	Parks           *ebpf.MapSpec `ebpf:"parks"`
+	RootEntries     *ebpf.MapSpec `ebpf:"root_entries"`
+	ShouldTraceGoid *ebpf.MapSpec `ebpf:"should_trace_goid"`
+	ShouldTraceRip  *ebpf.MapSpec `ebpf:"should_trace_rip"`
+	Spawns          *ebpf.MapSpec `ebpf:"spawns"`
+	StatRoots       *ebpf.MapSpec `ebpf:"stat_roots"`
+	StatStarts      *ebpf.MapSpec `ebpf:"stat_starts"`
+}
+
+// GofuncgraphObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to LoadGofuncgraphObjects or ebpf.CollectionSpec.LoadAndAssign.
+type GofuncgraphObjects struct {
+	GofuncgraphPrograms
+	GofuncgraphMaps
+}
+
+func (o *GofuncgraphObjects) Close() error {
+	return _GofuncgraphClose(
+		&o.GofuncgraphPrograms,
+		&o.GofuncgraphMaps,
+	)
+}
+
+// GofuncgraphMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to LoadGofuncgraphObjects or ebpf.CollectionSpec.LoadAndAssign.
+type GofuncgraphMaps struct {
+	ArgRulesMap     *ebpf.Map `ebpf:"arg_rules_map"`
+	EventPerfArray  *ebpf.Map `ebpf:"event_perf_array"`
+	EventRingbuf    *ebpf.Map `ebpf:"event_ringbuf"`
+	EventStack      *ebpf.Map `ebpf:"event_stack"`
+	FuncStats       *ebpf.Map `ebpf:"func_stats"`
+	GoidDepths      *ebpf.Map `ebpf:"goid_depths"`
+	GoidLostEvents  *ebpf.Map `ebpf:"goid_lost_events"`
+	LostEvents      *ebpf.Map `ebpf:"lost_events"`
+	Parks           *ebpf.Map `ebpf:"parks"`
+	RootEntries     *ebpf.Map `ebpf:"root_entries"`
+	ShouldTraceGoid *ebpf.Map `ebpf:"should_trace_goid"`
+	ShouldTraceRip  *ebpf.Map `ebpf:"should_trace_rip"`
+	Spawns          *ebpf.Map `ebpf:"spawns"`
+	StatRoots       *ebpf.Map `ebpf:"stat_roots"`
+	StatStarts      *ebpf.Map `ebpf:"stat_starts"`
+}
+
+func (m *GofuncgraphMaps) Close() error {
+	return _GofuncgraphClose(
+		m.ArgRulesMap,
+		m.EventPerfArray,
+		m.EventRingbuf,
+		m.EventStack,
+		m.FuncStats,
+		m.GoidDepths,
+		m.GoidLostEvents,
+		m.LostEvents,
+		m.Parks,
+		m.RootEntries,
+		m.ShouldTraceGoid,
+		m.ShouldTraceRip,
+		m.Spawns,
+		m.StatRoots,
+		m.StatStarts,
+	)
+}
+
+// GofuncgraphPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to LoadGofuncgraphObjects or ebpf.CollectionSpec.LoadAndAssign.
+type GofuncgraphPrograms struct {
+	Copystack      *ebpf.Program `ebpf:"copystack"`
+	Ent            *ebpf.Program `ebpf:"ent"`
+	Gopanic        *ebpf.Program `ebpf:"gopanic"`
+	Gopark         *ebpf.Program `ebpf:"gopark"`
+	GoparkRet      *ebpf.Program `ebpf:"gopark_ret"`
+	Gorecover      *ebpf.Program `ebpf:"gorecover"`
+	GoroutineExit  *ebpf.Program `ebpf:"goroutine_exit"`
+	GoroutineReady *ebpf.Program `ebpf:"goroutine_ready"`
+	Newproc        *ebpf.Program `ebpf:"newproc"`
+	NewprocRet     *ebpf.Program `ebpf:"newproc_ret"`
+	Recovery       *ebpf.Program `ebpf:"recovery"`
+	Ret            *ebpf.Program `ebpf:"ret"`
+	StatEnt        *ebpf.Program `ebpf:"stat_ent"`
+	StatRet        *ebpf.Program `ebpf:"stat_ret"`
+}
+
+func (p *GofuncgraphPrograms) Close() error {
+	return _GofuncgraphClose(
+		p.Copystack,
+		p.Ent,
+		p.Gopanic,
+		p.Gopark,
+		p.GoparkRet,
+		p.Gorecover,
+		p.GoroutineExit,
+		p.GoroutineReady,
+		p.Newproc,
+		p.NewprocRet,
+		p.Recovery,
+		p.Ret,
+		p.StatEnt,
+		p.StatRet,
+	)
+}
+
+func _GofuncgraphClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed gofuncgraph_x86_bpfel.o
+var _GofuncgraphBytes []byte
@@ -0,0 +1,63 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+	log "github.com/sirupsen/logrus"
+)
+
+const perfBufferPages = 1024
+
+// recordReader blocks on epoll until a record is submitted by bpf.
+type recordReader interface {
+	Read() ([]byte, error)
+	Close() error
+}
+
+type ringbufReader struct {
+	*ringbuf.Reader
+}
+
+func (r *ringbufReader) Read() ([]byte, error) {
+	record, err := r.Reader.Read()
+	if errors.Is(err, ringbuf.ErrClosed) {
+		return nil, errReaderClosed
+	}
+	return record.RawSample, err
+}
+
+type perfReader struct {
+	*perf.Reader
+}
+
+func (r *perfReader) Read() ([]byte, error) {
+	for {
+		record, err := r.Reader.Read()
+		if errors.Is(err, perf.ErrClosed) {
+			return nil, errReaderClosed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.LostSamples > 0 {
+			log.Warnf("lost %d samples on cpu %d", record.LostSamples, record.CPU)
+			continue
+		}
+		return record.RawSample, nil
+	}
+}
+
+var errReaderClosed = errors.New("reader closed")
+
+func (b *BPF) newReader(ringbufMap, perfArray *ebpf.Map) (recordReader, error) {
+	if b.useRingbuf {
+		reader, err := ringbuf.NewReader(ringbufMap)
+		return &ringbufReader{reader}, err
+	}
+	reader, err := perf.NewReader(perfArray, perfBufferPages*os.Getpagesize())
+	return &perfReader{reader}, err
+}
@@ -16,21 +16,42 @@ type Event struct {
 	bpf.GofuncgraphEvent
 	uprobe    *uprobe.Uprobe
 	argString string
+	// unwound is set on the synthetic return of a frame unwound by panic
+	unwound bool
+	// frameIp is the entry of the frame resumed by a recovery
+	frameIp uint64
+}
+
+// spawn is where a traced goroutine was created by the go statement.
+type spawn struct {
+	parentGoid uint64
+	frameIp    uint64
+	event      Event
 }
 
 type EventManager struct {
 	elf     *elf.ELF
-	argCh   <-chan bpf.GofuncgraphArgData
 	uprobes map[string]uprobe.Uprobe
 
 	goEvents     map[uint64][]Event
 	goEventStack map[uint64]uint64
-	goArgs       map[uint64]chan bpf.GofuncgraphArgData
+	goLost       map[uint64]uint64
+	goParents    map[uint64]spawn
+	goRecovery   map[uint64]int
+
+	waitReasons []string
+	bootTime    time.Time
+	opts        Options
+}
 
-	bootTime time.Time
+type Options struct {
+	// Threshold skips printing the call trees faster than it
+	Threshold time.Duration
+	// Sched prints the on-cpu, runnable and blocked time of frames
+	Sched bool
 }
 
-func New(uprobes []uprobe.Uprobe, elf *elf.ELF, ch <-chan bpf.GofuncgraphArgData) (_ *EventManager, err error) {
+func New(uprobes []uprobe.Uprobe, elf *elf.ELF, opts Options) (_ *EventManager, err error) {
 	host, err := sysinfo.Host()
 	if err != nil {
 		return
@@ -40,27 +61,23 @@ func New(uprobes []uprobe.Uprobe, elf *elf.ELF, ch <-chan bpf.GofuncgraphArgData
 	for _, up := range uprobes {
 		uprobesMap[fmt.Sprintf("%s+%d", up.Funcname, up.RelOffset)] = up
 	}
-	m := &EventManager{
+	waitReasons, err := elf.GoStrings("runtime.waitReasonStrings")
+	if err != nil {
+		log.Debugf("failed to read wait reasons: %+v", err)
+		err = nil
+	}
+	return &EventManager{
 		elf:          elf,
-		argCh:        ch,
 		uprobes:      uprobesMap,
 		goEvents:     map[uint64][]Event{},
 		goEventStack: map[uint64]uint64{},
-		goArgs:       map[uint64]chan bpf.GofuncgraphArgData{},
+		goLost:       map[uint64]uint64{},
+		goParents:    map[uint64]spawn{},
+		goRecovery:   map[uint64]int{},
+		waitReasons:  waitReasons,
 		bootTime:     bootTime,
-	}
-	go m.handleArg()
-	return m, err
-}
-
-func (m *EventManager) handleArg() {
-	for arg := range m.argCh {
-		if _, ok := m.goArgs[arg.Goid]; !ok {
-			m.goArgs[arg.Goid] = make(chan bpf.GofuncgraphArgData, 1000)
-		}
-		log.Debugf("add arg %+v", arg)
-		m.goArgs[arg.Goid] <- arg
-	}
+		opts:         opts,
+	}, nil
 }
 
 func (m *EventManager) GetUprobe(event bpf.GofuncgraphEvent) (_ uprobe.Uprobe, err error) {
@@ -1,6 +1,9 @@
 package eventmanager
 
 import (
+	"encoding/binary"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -9,11 +12,59 @@ import (
 )
 
 func (m *EventManager) Handle(event bpf.GofuncgraphEvent) (err error) {
+	if event.Lost > 0 {
+		log.Debugf("%d events lost before event: %+v", event.Lost, event)
+		m.goLost[event.Goid] += uint64(event.Lost)
+		if len(m.goEvents[event.Goid]) > 0 {
+			// the open tree can never be closed correctly, and takes
+			// the lost count with it
+			if m.OpenElapsed(event.Goid, event.TimeNs) >= m.opts.Threshold {
+				if err = m.PrintStack(event.Goid); err != nil {
+					return
+				}
+			}
+			m.ClearStack(event)
+		}
+	}
+
+	switch event.Location {
+	case 2:
+		m.Spawn(event)
+		return
+	case 3, 4, 7:
+		m.Annotate(event)
+		return
+	case 5:
+		m.Recover(event)
+		return
+	case 6:
+		return m.Exit(event)
+	}
+
+	if idx, ok := m.goRecovery[event.Goid]; ok {
+		delete(m.goRecovery, event.Goid)
+		m.Unwind(event, idx)
+		if m.CloseStack(event) {
+			if m.RootElapsed(event) >= m.opts.Threshold {
+				if err = m.PrintStack(event.Goid); err != nil {
+					return err
+				}
+			}
+			m.ClearStack(event)
+		}
+	}
+
 	m.Add(event)
+	if len(m.goEvents[event.Goid]) == 0 {
+		delete(m.goLost, event.Goid)
+		return
+	}
 	log.Debugf("added event: %+v", event)
 	if m.CloseStack(event) {
-		if err = m.PrintStack(event.Goid); err != nil {
-			return err
+		if m.RootElapsed(event) >= m.opts.Threshold {
+			if err = m.PrintStack(event.Goid); err != nil {
+				return err
+			}
 		}
 		m.ClearStack(event)
 	}
@@ -25,49 +76,148 @@ func (m *EventManager) Add(event bpf.GofuncgraphEvent) {
 	if length == 0 && event.Location != 0 {
 		return
 	}
-	uprobe, err := m.GetUprobe(event)
-	if err != nil {
-		log.Errorf("failed to get uprobe for event %+v: %+v", event, err)
-		return
+	m.goEvents[event.Goid] = append(m.goEvents[event.Goid], Event{GofuncgraphEvent: event})
+	switch event.Location {
+	case 0:
+		m.goEventStack[event.Goid]++
+	case 1:
+		m.goEventStack[event.Goid]--
 	}
-	if length > 0 {
-		lastEvent := m.goEvents[event.Goid][length-1]
-		if lastEvent.Location == event.Location && lastEvent.Ip == event.Ip && lastEvent.Bp != event.CallerBp {
-			// duplicated entry event due to stack expansion/shrinkage
-			log.Debugf("duplicated entry event: %+v", event)
-			m.goEvents[event.Goid][length-1].GofuncgraphEvent = event
-			for range uprobe.FetchArgs {
-				for m.goArgs[event.Goid] == nil {
-					time.Sleep(time.Millisecond)
-				}
-				<-m.goArgs[event.Goid]
+}
+
+// Spawn links the goroutine created by the go statement to the innermost open
+// frame of its parent, and records the go statement in the parent's tree.
+func (m *EventManager) Spawn(event bpf.GofuncgraphEvent) {
+	goid := binary.LittleEndian.Uint64(event.Payload[:])
+	log.Debugf("goroutine %d spawned by goroutine %d: %+v", goid, event.Goid, event)
+	s := spawn{parentGoid: event.Goid, event: Event{GofuncgraphEvent: event}}
+	if frames := m.openFrames(event.Goid); len(frames) > 0 {
+		s.frameIp = m.goEvents[event.Goid][frames[len(frames)-1]].Ip
+	}
+	m.goParents[goid] = s
+	m.Annotate(event)
+}
+
+// Annotate appends the event to the open tree without changing the stack,
+// such as parking, panicking and stack copying.
+func (m *EventManager) Annotate(event bpf.GofuncgraphEvent) {
+	if len(m.goEvents[event.Goid]) > 0 {
+		m.goEvents[event.Goid] = append(m.goEvents[event.Goid], Event{GofuncgraphEvent: event})
+	}
+}
+
+// Recover records the recovery of a panic in the open tree. The frame to be
+// resumed is unknown until the next event from the goroutine.
+func (m *EventManager) Recover(event bpf.GofuncgraphEvent) {
+	if len(m.goEvents[event.Goid]) > 0 {
+		m.goRecovery[event.Goid] = len(m.goEvents[event.Goid])
+		m.Annotate(event)
+	}
+}
+
+// Unwind closes the frames unwound by the recovered panic, which are the open
+// frames below the frame of the first event after the recovery.
+func (m *EventManager) Unwind(event bpf.GofuncgraphEvent, idx int) {
+	bp := event.Bp
+	if event.Location == 0 {
+		bp = event.CallerBp
+	}
+	events := m.goEvents[event.Goid]
+	unwound := []Event{}
+	frames := m.openFrames(event.Goid)
+	for i := len(frames) - 1; i >= 0; i-- {
+		frame := events[frames[i]]
+		// bp is the offset from the stack top, so deeper frames have larger bp
+		if frame.Bp <= bp {
+			if frame.Bp == bp {
+				events[idx].frameIp = frame.Ip
 			}
-			return
+			break
 		}
+		unwound = append(unwound, Event{
+			GofuncgraphEvent: bpf.GofuncgraphEvent{
+				Goid:     frame.Goid,
+				Ip:       frame.Ip,
+				Bp:       frame.Bp,
+				TimeNs:   events[idx].TimeNs,
+				Location: 1,
+			},
+			unwound: true,
+		})
+		m.goEventStack[event.Goid]--
 	}
+	log.Debugf("%d frames unwound by recovery before event: %+v", len(unwound), event)
+	m.goEvents[event.Goid] = slices.Insert(events, idx, unwound...)
+}
 
+// openFrames returns the indexes of the entry events of the open frames,
+// from the root to the innermost.
+func (m *EventManager) openFrames(goid uint64) (frames []int) {
+	for i, event := range m.goEvents[goid] {
+		switch event.Location {
+		case 0:
+			frames = append(frames, i)
+		case 1:
+			if len(frames) > 0 {
+				frames = frames[:len(frames)-1]
+			}
+		}
+	}
+	return
+}
+
+// Exit prints the tree left open by the exited goroutine as truncated, and
+// frees all the state of the goroutine.
+func (m *EventManager) Exit(event bpf.GofuncgraphEvent) (err error) {
+	if len(m.goEvents[event.Goid]) > 0 {
+		m.Annotate(event)
+		if m.RootElapsed(event) >= m.opts.Threshold {
+			err = m.PrintStack(event.Goid)
+		}
+	}
+	m.ClearStack(event)
+	delete(m.goParents, event.Goid)
+	return
+}
+
+// RootElapsed returns the elapsed time of the closed tree, which is measured
+// in bpf by the time the root frame returns.
+func (m *EventManager) RootElapsed(event bpf.GofuncgraphEvent) time.Duration {
+	if event.RootNs > 0 {
+		return time.Duration(event.RootNs)
+	}
+	events := m.goEvents[event.Goid]
+	return time.Duration(events[len(events)-1].TimeNs - events[0].TimeNs)
+}
+
+// OpenElapsed returns the elapsed time of the open tree until ns, which is the
+// least time its root takes.
+func (m *EventManager) OpenElapsed(goid, ns uint64) time.Duration {
+	events := m.goEvents[goid]
+	if len(events) == 0 {
+		return 0
+	}
+	return time.Duration(ns - events[0].TimeNs)
+}
+
+// Resolve symbolizes the entry event and formats its fetched args, which is
+// deferred until printing so that discarded trees cost nothing. The event of
+// an unknown uprobe is printed by its address.
+func (m *EventManager) Resolve(event *Event) {
+	uprobe, err := m.GetUprobe(event.GofuncgraphEvent)
+	if err != nil {
+		log.Errorf("failed to get uprobe for event %+v: %+v", event.GofuncgraphEvent, err)
+		uprobe.Funcname = fmt.Sprintf("0x%x", event.Ip)
+	}
 	args := []string{}
 	for _, fetchArg := range uprobe.FetchArgs {
-		for m.goArgs[event.Goid] == nil {
-			time.Sleep(time.Millisecond)
-		}
-		arg := <-m.goArgs[event.Goid]
 		if len(args) > 0 {
 			args = append(args, ", ")
 		}
-		args = append(args, fetchArg.Varname, "=", fetchArg.SprintValue(arg.Data[:]))
-	}
-	m.goEvents[event.Goid] = append(m.goEvents[event.Goid], Event{
-		GofuncgraphEvent: event,
-		uprobe:           &uprobe,
-		argString:        strings.Join(args, ""),
-	})
-	switch event.Location {
-	case 0:
-		m.goEventStack[event.Goid]++
-	case 1:
-		m.goEventStack[event.Goid]--
+		args = append(args, fetchArg.Varname, "=", m.sprintValue(fetchArg, event.Payload[fetchArg.DataOffset:], event.LoadBias))
 	}
+	event.uprobe = &uprobe
+	event.argString = strings.Join(args, "")
 }
 
 func (m *EventManager) CloseStack(event bpf.GofuncgraphEvent) bool {
@@ -77,4 +227,6 @@ func (m *EventManager) CloseStack(event bpf.GofuncgraphEvent) bool {
 func (m *EventManager) ClearStack(event bpf.GofuncgraphEvent) {
 	delete(m.goEvents, event.Goid)
 	delete(m.goEventStack, event.Goid)
+	delete(m.goLost, event.Goid)
+	delete(m.goRecovery, event.Goid)
 }
@@ -1,11 +1,17 @@
 package eventmanager
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jschwinger233/gofuncgraph/internal/bpf"
 	"github.com/jschwinger233/gofuncgraph/internal/uprobe"
+	"golang.org/x/sys/unix"
 )
 
 const placeholder = "        "
@@ -24,7 +30,16 @@ func (m *EventManager) SprintCallChain(event Event) (chain string, err error) {
 func (m *EventManager) PrintStack(goid uint64) (err error) {
 	indent := ""
 	fmt.Println()
-	startTimeStack := []uint64{}
+	if lost := m.goLost[goid]; lost > 0 {
+		fmt.Printf("[incomplete: %d events lost]\n", lost)
+	}
+	if events := m.goEvents[goid]; len(events) > 0 && events[len(events)-1].Location == 6 {
+		fmt.Printf("[truncated: goroutine %d exited with %d open frames]\n", goid, len(m.openFrames(goid)))
+	}
+	if parent, ok := m.goParents[goid]; ok {
+		fmt.Printf("goroutine %d spawned by goroutine %d%s\n", goid, parent.parentGoid, m.sprintSpawnSite(parent))
+	}
+	frames := []*frameTime{}
 	for _, event := range m.goEvents[goid] {
 		lineInfo := "?:?"
 		t := m.bootTime.Add(time.Duration(event.TimeNs)).Format("02 15:04:05.0000")
@@ -35,7 +50,8 @@ func (m *EventManager) PrintStack(goid uint64) (err error) {
 
 		switch event.Location {
 		case 0: // entpoint
-			startTimeStack = append(startTimeStack, event.TimeNs)
+			m.Resolve(&event)
+			frames = append(frames, &frameTime{startNs: event.TimeNs, reasons: map[uint8]uint64{}})
 			callChain, err := m.SprintCallChain(event)
 			if err != nil {
 				return err
@@ -51,43 +67,220 @@ func (m *EventManager) PrintStack(goid uint64) (err error) {
 			if len(indent) == 0 {
 				continue
 			}
+			if event.unwound {
+				frame := frames[len(frames)-1]
+				frames = frames[:len(frames)-1]
+				elapsed := event.TimeNs - frame.startNs
+				indent = indent[:len(indent)-2]
+				fmt.Printf("%s %08.4f %s } %s panicked%s\n", t, time.Duration(elapsed).Seconds(), indent, syms[0].Name, m.sprintFrameTime(frame, elapsed))
+				continue
+			}
 			if filename, line, err := m.elf.LineInfoForPc(event.Ip); err == nil {
 				lineInfo = fmt.Sprintf("%s:%d", filename, line)
 			}
-			elapsed := event.TimeNs - startTimeStack[len(startTimeStack)-1]
-			startTimeStack = startTimeStack[:len(startTimeStack)-1]
+			m.Resolve(&event)
+			results := ""
+			if len(event.uprobe.FetchArgs) > 0 {
+				results = fmt.Sprintf(" -> (%s)", event.argString)
+			}
+			frame := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			elapsed := event.TimeNs - frame.startNs
 			indent = indent[:len(indent)-2]
-			fmt.Printf("%s %08.4f %s } %s+%d %s\n", t, time.Duration(elapsed).Seconds(), indent, syms[0].Name, offset, lineInfo)
+			fmt.Printf("%s %08.4f %s } %s+%d%s %s%s\n", t, time.Duration(elapsed).Seconds(), indent, syms[0].Name, offset, results, lineInfo, m.sprintFrameTime(frame, elapsed))
+
+		case 2: // spawnpoint
+			callChain, err := m.SprintCallChain(event)
+			if err != nil {
+				return err
+			}
+			if filename, line, err := m.elf.LineInfoForPc(event.CallerIp); err == nil {
+				lineInfo = fmt.Sprintf("%s:%d", filename, line)
+			}
+			fmt.Printf("%s %s %s go goroutine %d %s %s\n", t, placeholder, indent, binary.LittleEndian.Uint64(event.Payload[:]), callChain, lineInfo)
+
+		case 3: // parkpoint
+			var park bpf.GofuncgraphParkData
+			if err = binary.Read(bytes.NewReader(event.Payload[:]), binary.LittleEndian, &park); err != nil {
+				return err
+			}
+			for _, frame := range frames {
+				frame.runnableNs += park.RunnableNs
+				frame.blockedNs += park.BlockedNs
+				frame.reasons[park.Reason] += park.BlockedNs
+			}
+
+		case 4: // panicpoint
+			var panicData bpf.GofuncgraphPanicData
+			if err = binary.Read(bytes.NewReader(event.Payload[:]), binary.LittleEndian, &panicData); err != nil {
+				return err
+			}
+			callChain, err := m.SprintCallChain(event)
+			if err != nil {
+				return err
+			}
+			if filename, line, err := m.elf.LineInfoForPc(event.CallerIp); err == nil {
+				lineInfo = fmt.Sprintf("%s:%d", filename, line)
+			}
+			fmt.Printf("%s %s %s panic(%s) %s %s\n", t, placeholder, indent, m.sprintPanicValue(panicData), callChain, lineInfo)
+
+		case 5: // recoverpoint
+			recovered := "recovered"
+			if syms, _, err := m.elf.ResolveAddress(event.CallerIp); err == nil {
+				recovered += " by " + syms[0].Name
+			}
+			if syms, _, err := m.elf.ResolveAddress(event.frameIp); err == nil {
+				recovered += " in " + syms[0].Name
+			}
+			fmt.Printf("%s %s %s %s\n", t, placeholder, indent, recovered)
+
+		case 7: // stackpoint
+			var stack bpf.GofuncgraphStackData
+			if err = binary.Read(bytes.NewReader(event.Payload[:]), binary.LittleEndian, &stack); err != nil {
+				return err
+			}
+			copied := "grown"
+			if stack.NewSize < stack.OldSize {
+				copied = "shrunk"
+			}
+			fmt.Printf("%s %s %s [stack %s from %d to %d bytes]\n", t, placeholder, indent, copied, stack.OldSize, stack.NewSize)
 		}
 
 	}
 	return
 }
 
-func (m *EventManager) SprintArg(arg *uprobe.FetchArg, data []uint8) (_ string, err error) {
-	value := arg.SprintValue(data)
-	if arg.Varname != "__call__" {
-		return fmt.Sprintf("%s=%s", arg.Varname, value), nil
+// frameTime splits the wall time of a frame into on-cpu, runnable and
+// blocked time, which is only known with Options.Sched.
+type frameTime struct {
+	startNs    uint64
+	runnableNs uint64
+	blockedNs  uint64
+	reasons    map[uint8]uint64
+}
+
+func (m *EventManager) sprintFrameTime(frame *frameTime, elapsed uint64) string {
+	if !m.opts.Sched {
+		return ""
 	}
-	addr, err := strconv.ParseUint(value, 10, 64)
-	if err != nil {
-		return "", err
+	onCPU := uint64(0)
+	if elapsed > frame.runnableNs+frame.blockedNs {
+		onCPU = elapsed - frame.runnableNs - frame.blockedNs
+	}
+	s := fmt.Sprintf(" [on-cpu %.4f runnable %.4f blocked %.4f", time.Duration(onCPU).Seconds(), time.Duration(frame.runnableNs).Seconds(), time.Duration(frame.blockedNs).Seconds())
+	reasons := []uint8{}
+	for reason := range frame.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return frame.reasons[reasons[i]] > frame.reasons[reasons[j]] })
+	for i, reason := range reasons {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		s += fmt.Sprintf("%s%s %.4f", sep, m.waitReason(reason), time.Duration(frame.reasons[reason]).Seconds())
 	}
-	syms, offset, err := m.elf.ResolveAddress(addr)
+	return s + "]"
+}
+
+func (m *EventManager) waitReason(reason uint8) string {
+	if int(reason) < len(m.waitReasons) && m.waitReasons[reason] != "" {
+		return m.waitReasons[reason]
+	}
+	return fmt.Sprintf("waitReason(%d)", reason)
+}
+
+// sprintPanicValue formats the panic value, whose message is only known for
+// string and the error types holding a string at the start.
+func (m *EventManager) sprintPanicValue(data bpf.GofuncgraphPanicData) string {
+	name, err := m.elf.TypeName(data.Type)
 	if err != nil {
-		return "", err
+		return fmt.Sprintf("type@0x%x", data.Type)
 	}
-	if offset != 0 {
-		return "", fmt.Errorf("not a valid __call__ target: %lld", addr)
+	msg := strconv.Quote(string(data.Msg[:data.MsgLen]))
+	switch name {
+	case "string":
+		return msg
+	case "runtime.plainError", "runtime.errorString", "*errors.errorString", "*fmt.wrapError", "*fmt.wrapErrors":
+		return fmt.Sprintf("%s %s", name, msg)
 	}
-	return fmt.Sprintf("__call__=%s", syms[0].Name), nil
+	return name
 }
 
+func (m *EventManager) sprintSpawnSite(s spawn) string {
+	site := ""
+	if s.frameIp != 0 {
+		if syms, _, err := m.elf.ResolveAddress(s.frameIp); err == nil {
+			site += " in " + syms[0].Name
+		}
+	}
+	if filename, line, err := m.elf.LineInfoForPc(s.event.CallerIp); err == nil {
+		site += fmt.Sprintf(" at %s:%d", filename, line)
+	}
+	return site
+}
+
+// sprintValue formats the fetched arg, resolving the dynamic types of
+// interfaces, also those in structs, by the binary loaded at loadBias.
+func (m *EventManager) sprintValue(arg *uprobe.FetchArg, data []uint8, loadBias uint64) string {
+	typeName := func(typ uint64) string {
+		name, err := m.elf.TypeName(typ - loadBias)
+		if err != nil {
+			return fmt.Sprintf("type@0x%x", typ)
+		}
+		return name
+	}
+	switch arg.Type {
+	case "iface", "eface":
+		return arg.SprintIface(data, typeName(binary.LittleEndian.Uint64(data)))
+	case "struct":
+		return arg.SprintStruct(data, typeName)
+	}
+	return arg.SprintValue(data)
+}
+
+// PrintRemaining prints the trees left open when tracing stops, whose roots
+// have run longer than the threshold by now.
 func (m *EventManager) PrintRemaining() (err error) {
+	// bpf_ktime_get_ns is the monotonic clock
+	var now unix.Timespec
+	if err = unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return
+	}
 	for goid := range m.goEvents {
+		if m.OpenElapsed(goid, uint64(now.Nano())) < m.opts.Threshold {
+			continue
+		}
 		if err = m.PrintStack(goid); err != nil {
 			break
 		}
 	}
 	return
 }
+
+func (m *EventManager) PrintLostEvents(lost map[uint64][]uint64) {
+	total := uint64(0)
+	for _, counts := range lost {
+		for _, count := range counts {
+			total += count
+		}
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Printf("\n%d events lost:\n", total)
+	for ip, counts := range lost {
+		probe := fmt.Sprintf("0x%x", ip)
+		if syms, offset, err := m.elf.ResolveAddress(ip); err == nil {
+			probe = fmt.Sprintf("%s+%d", syms[0].Name, offset)
+		}
+		perCPU := []string{}
+		for cpu, count := range counts {
+			if count > 0 {
+				perCPU = append(perCPU, fmt.Sprintf("cpu%d=%d", cpu, count))
+			}
+		}
+		fmt.Printf("  %s %s\n", probe, strings.Join(perCPU, " "))
+	}
+}
@@ -0,0 +1,81 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+type Process struct {
+	Pid int
+
+	cmd *exec.Cmd
+}
+
+// Launch starts argv and keeps it stopped right after execve(2), before any
+// instruction of the new image runs, until Resume is called. Launch and Resume
+// must be called from the same goroutine, as ptrace(2) requests are only
+// accepted from the tracing thread.
+func Launch(argv []string) (_ *Process, err error) {
+	if len(argv) == 0 {
+		return nil, errors.New("empty command")
+	}
+	bin, err := exec.LookPath(argv[0])
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command(bin, argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+
+	runtime.LockOSThread()
+	if err = cmd.Start(); err != nil {
+		runtime.UnlockOSThread()
+		return
+	}
+
+	var status syscall.WaitStatus
+	if _, err = syscall.Wait4(cmd.Process.Pid, &status, 0, nil); err != nil {
+		runtime.UnlockOSThread()
+		return
+	}
+	if !status.Stopped() {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to stop %s after exec: %v", bin, status)
+	}
+
+	return &Process{
+		Pid: cmd.Process.Pid,
+		cmd: cmd,
+	}, nil
+}
+
+func (p *Process) Resume() (err error) {
+	defer runtime.UnlockOSThread()
+	return syscall.PtraceDetach(p.Pid)
+}
+
+func (p *Process) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+// Wait waits for the process to exit and returns its exit code, a process
+// killed by signal N reports 128+N as a shell does.
+func (p *Process) Wait() (code int, err error) {
+	err = p.cmd.Wait()
+	exitErr := &exec.ExitError{}
+	if err != nil && !errors.As(err, &exitErr) {
+		return
+	}
+	status := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return status.ExitStatus(), nil
+}
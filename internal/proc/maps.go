@@ -0,0 +1,45 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExeMapping returns the start address and file offset of the lowest mapping
+// of the executable of pid, from which the load bias is calculated.
+func ExeMapping(pid int) (start, offset uint64, err error) {
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	maps, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	defer maps.Close()
+
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		// 55d0c5e4c000-55d0c5f2a000 r-xp 00000000 fd:01 1234 /usr/bin/foo
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || strings.Join(fields[5:], " ") != target {
+			continue
+		}
+		var end uint64
+		if _, err = fmt.Sscanf(fields[0], "%x-%x", &start, &end); err != nil {
+			return 0, 0, errors.WithStack(err)
+		}
+		if _, err = fmt.Sscanf(fields[2], "%x", &offset); err != nil {
+			return 0, 0, errors.WithStack(err)
+		}
+		return
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	return 0, 0, fmt.Errorf("mapping of %s not found in pid %d", target, pid)
+}
@@ -0,0 +1,50 @@
+package proc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jschwinger233/gofuncgraph/elf"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExePath returns a path to the executable of pid which is suitable for
+// attaching uprobes, taking the mount namespace of the process into account.
+func ExePath(pid int) (path string, err error) {
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+	target, err := os.Readlink(exe)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	path = filepath.Join(fmt.Sprintf("/proc/%d/root", pid), target)
+	if _, err = os.Stat(path); err != nil {
+		// binary is deleted, fall back to the running image
+		return exe, nil
+	}
+
+	same, err := sameBuildID(path, exe)
+	if err != nil {
+		return
+	}
+	if !same {
+		// binary is replaced, fall back to the running image
+		log.Debugf("%s is replaced since pid %d started, use %s instead", path, pid, exe)
+		return exe, nil
+	}
+	return path, nil
+}
+
+func sameBuildID(path, exe string) (same bool, err error) {
+	diskID, err := elf.ReadBuildID(path)
+	if err != nil {
+		return
+	}
+	runningID, err := elf.ReadBuildID(exe)
+	if err != nil {
+		return
+	}
+	return diskID == runningID, nil
+}
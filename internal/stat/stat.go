@@ -0,0 +1,94 @@
+package stat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jschwinger233/gofuncgraph/elf"
+	"github.com/jschwinger233/gofuncgraph/internal/bpf"
+)
+
+const barWidth = 40
+
+type Printer struct {
+	elf  *elf.ELF
+	last map[uint64]bpf.GofuncgraphFuncStat
+}
+
+func New(elf *elf.ELF) *Printer {
+	return &Printer{
+		elf:  elf,
+		last: map[uint64]bpf.GofuncgraphFuncStat{},
+	}
+}
+
+type funcStat struct {
+	name string
+	bpf.GofuncgraphFuncStat
+}
+
+// Print prints the histograms accumulated since the last call.
+func (p *Printer) Print(stats map[uint64]bpf.GofuncgraphFuncStat) {
+	funcStats := []funcStat{}
+	for ip, stat := range stats {
+		last := p.last[ip]
+		p.last[ip] = stat
+
+		stat.Count -= last.Count
+		stat.TotalNs -= last.TotalNs
+		for i := range stat.Slots {
+			stat.Slots[i] -= last.Slots[i]
+		}
+		if stat.Count == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("0x%x", ip)
+		if syms, _, err := p.elf.ResolveAddress(ip); err == nil {
+			name = syms[0].Name
+		}
+		funcStats = append(funcStats, funcStat{name: name, GofuncgraphFuncStat: stat})
+	}
+	if len(funcStats) == 0 {
+		return
+	}
+
+	sort.Slice(funcStats, func(i, j int) bool { return funcStats[i].name < funcStats[j].name })
+	fmt.Printf("\n%s\n", time.Now().Format("15:04:05"))
+	for _, stat := range funcStats {
+		avg := time.Duration(stat.TotalNs / stat.Count)
+		fmt.Printf("\n%s: count %d, avg %s, total %s\n", stat.name, stat.Count, avg, time.Duration(stat.TotalNs))
+		printLog2Hist(stat.Slots[:])
+	}
+}
+
+func printLog2Hist(slots []uint64) {
+	first, last, max := -1, -1, uint64(0)
+	for i, count := range slots {
+		if count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if count > max {
+			max = count
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	fmt.Printf("%24s : %-8s  distribution\n", "nsecs", "count")
+	for i := first; i <= last; i++ {
+		low, high := uint64(1)<<i, uint64(1)<<(i+1)-1
+		if i == 0 {
+			low = 0
+		}
+		bar := strings.Repeat("*", int(slots[i]*barWidth/max))
+		fmt.Printf("%10d -> %-10d : %-8d |%-*s|\n", low, high, slots[i], barWidth, bar)
+	}
+}
@@ -0,0 +1,374 @@
+package uprobe
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/jschwinger233/gofuncgraph/elf"
+)
+
+// registers are indexed by the DWARF register number.
+var registers = []string{"ax", "dx", "cx", "bx", "si", "di", "bp", "sp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+
+// value is where an expression is at the function entry, either the pieces
+// of a parameter in registers or on the stack, or the rules to fetch it.
+type value struct {
+	typ    godwarf.Type
+	pieces []elf.Piece
+	rules  []*ArgRule
+}
+
+// maxDataSize is MAX_DATA_SIZE in bpf, which limits the bytes read from
+// strings and slices.
+const maxDataSize = 64
+
+// newExprFetchArg compiles the Go expression on the parameters, such as
+// r.URL.Path, into the rules to fetch it at the function entry.
+func newExprFetchArg(e *elf.ELF, varname, expr string, params []elf.Param) (_ *FetchArg, err error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %s: %w", expr, err)
+	}
+	v, err := evalExpr(e, node, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", expr, err)
+	}
+	fa := &FetchArg{Varname: varname, Statement: expr, TypeName: v.typ.String()}
+	if err = v.compile(fa); err != nil {
+		return nil, fmt.Errorf("%s: %w", expr, err)
+	}
+	return fa, nil
+}
+
+// compile generates the fetches of the value by its type. Strings and slices
+// are fetched as the length, the capacity and then the elements limited by
+// the length, maps are fetched as the count in the map header, and
+// interfaces are fetched as the type descriptor and the data pointer.
+func (v value) compile(fa *FetchArg) (err error) {
+	switch typ := resolveTypedef(v.typ).(type) {
+	case *godwarf.InterfaceType:
+		fa.Fetches, err = v.ifaceFetches(fa)
+	case *godwarf.StringType:
+		fa.Type = "string"
+		fa.Fetches, err = v.fetches([]string{"len"}, "str", 1)
+	case *godwarf.SliceType:
+		elem := resolveTypedef(typ.ElemType)
+		fa.Elem = scalarKind(elem)
+		switch {
+		case fa.Elem == "u8":
+			fa.Type = "bytes"
+		case fa.Elem != "":
+			fa.Type = "slice"
+		default:
+			return fmt.Errorf("cannot fetch elements of type %s", typ.ElemType)
+		}
+		fa.Fetches, err = v.fetches([]string{"len", "cap"}, "array", int(elem.Size()))
+	case *godwarf.MapType:
+		fa.Type = "map"
+		if v.rules == nil {
+			if v, err = v.single(); err != nil {
+				return
+			}
+		}
+		// the count is the first field of the map header
+		fa.Fetches = []*ArgFetch{{Rules: append(clone(v.rules), &ArgRule{From: Stack}), Size: 8}}
+	default:
+		if fa.Type = scalarKind(typ); fa.Type == "" {
+			return fmt.Errorf("cannot fetch value of type %s", v.typ)
+		}
+		if v.rules == nil {
+			if v, err = v.single(); err != nil {
+				return
+			}
+		}
+		fa.Fetches = []*ArgFetch{{Rules: v.rules, Size: int(typ.Size())}}
+	}
+	if err != nil {
+		return
+	}
+	for _, fetch := range fa.Fetches {
+		fa.Size = max(fa.Size, fetch.Offset+fetch.Size)
+	}
+	return
+}
+
+// ifaceFetches fetches the _type of eface, or itab._type of iface, followed
+// by the data pointer.
+func (v value) ifaceFetches(fa *FetchArg) (fetches []*ArgFetch, err error) {
+	typ, err := v.field("_type")
+	if err == nil {
+		fa.Type = "eface"
+	} else {
+		if typ, err = v.field("tab"); err != nil {
+			return
+		}
+		if typ.rules == nil {
+			if typ, err = typ.single(); err != nil {
+				return
+			}
+		}
+		// _type is right after inter in runtime.itab
+		typ.rules = append(clone(typ.rules), &ArgRule{From: Stack, Offset: 8})
+		fa.Type = "iface"
+	}
+	if typ.rules == nil {
+		if typ, err = typ.single(); err != nil {
+			return
+		}
+	}
+	data, err := v.field("data")
+	if err == nil && data.rules == nil {
+		data, err = data.single()
+	}
+	if err != nil {
+		return
+	}
+	return []*ArgFetch{{Rules: typ.rules, Size: 8}, {Rules: data.rules, Offset: 8, Size: 8}}, nil
+}
+
+// fetches fetches the length fields of the header each in 8 bytes, followed
+// by the elements pointed by the data field.
+func (v value) fetches(lengths []string, data string, elemSize int) (fetches []*ArgFetch, err error) {
+	for _, name := range lengths {
+		field, err := v.field(name)
+		if err == nil && field.rules == nil {
+			field, err = field.single()
+		}
+		if err != nil {
+			return nil, err
+		}
+		fetches = append(fetches, &ArgFetch{Rules: field.rules, Offset: len(fetches) * 8, Size: 8})
+	}
+	ptr, err := v.field(data)
+	if err != nil {
+		return
+	}
+	elems, err := ptr.deref()
+	if err != nil {
+		return
+	}
+	return append(fetches, &ArgFetch{
+		Rules:    elems.rules,
+		Offset:   len(fetches) * 8,
+		Size:     maxDataSize / elemSize * elemSize,
+		ElemSize: elemSize,
+	}), nil
+}
+
+func scalarKind(typ godwarf.Type) string {
+	size := typ.Size()
+	switch typ.(type) {
+	case *godwarf.IntType:
+		return fmt.Sprintf("s%d", size*8)
+	case *godwarf.UintType, *godwarf.CharType, *godwarf.UcharType:
+		return fmt.Sprintf("u%d", size*8)
+	case *godwarf.FloatType:
+		return fmt.Sprintf("f%d", size*8)
+	case *godwarf.BoolType:
+		return "bool"
+	case *godwarf.PtrType, *godwarf.FuncType, *godwarf.ChanType:
+		return "ptr"
+	}
+	return ""
+}
+
+func evalExpr(e *elf.ELF, node ast.Expr, params []elf.Param) (v value, err error) {
+	switch node := node.(type) {
+	case *ast.Ident:
+		for _, param := range params {
+			if param.Name == node.Name {
+				return value{typ: param.Type, pieces: param.Pieces}, nil
+			}
+		}
+		return v, fmt.Errorf("no parameter named %s", node.Name)
+
+	case *ast.ParenExpr:
+		return evalExpr(e, node.X, params)
+
+	case *ast.UnaryExpr:
+		// unnamed results are named ~r0, ~r1 and so on
+		if ident, ok := node.X.(*ast.Ident); ok && node.Op == token.TILDE {
+			return evalExpr(e, &ast.Ident{Name: "~" + ident.Name}, params)
+		}
+
+	case *ast.StarExpr:
+		if v, err = evalExpr(e, node.X, params); err != nil {
+			return
+		}
+		return v.deref()
+
+	case *ast.SelectorExpr:
+		if v, err = evalExpr(e, node.X, params); err != nil {
+			return
+		}
+		if _, ok := resolveTypedef(v.typ).(*godwarf.PtrType); ok {
+			if v, err = v.deref(); err != nil {
+				return
+			}
+		}
+		return v.field(node.Sel.Name)
+
+	case *ast.TypeAssertExpr:
+		if v, err = evalExpr(e, node.X, params); err != nil {
+			return
+		}
+		if node.Type == nil {
+			return v, fmt.Errorf("unsupported type switch")
+		}
+		typ, err := e.TypeByName(types.ExprString(node.Type))
+		if err != nil {
+			return v, err
+		}
+		return v.assert(typ)
+	}
+	return v, fmt.Errorf("unsupported expression %T", node)
+}
+
+// single turns the value held by a single piece into rules.
+func (v value) single() (_ value, err error) {
+	if len(v.pieces) != 1 {
+		return v, fmt.Errorf("value of type %s is split into %d pieces, select a field of it", v.typ, len(v.pieces))
+	}
+	piece := v.pieces[0]
+	if piece.Register < 0 {
+		v.rules = []*ArgRule{{From: Register, Register: "sp"}, {From: Stack, Offset: piece.Offset}}
+	} else if piece.Register < len(registers) {
+		v.rules = []*ArgRule{{From: Register, Register: registers[piece.Register]}}
+	} else {
+		return v, fmt.Errorf("unsupported register %d", piece.Register)
+	}
+	v.pieces = nil
+	return v, nil
+}
+
+func (v value) deref() (_ value, err error) {
+	ptr, ok := resolveTypedef(v.typ).(*godwarf.PtrType)
+	if !ok {
+		return v, fmt.Errorf("cannot dereference value of type %s", v.typ)
+	}
+	if v.rules == nil {
+		if v, err = v.single(); err != nil {
+			return
+		}
+	}
+	if len(v.rules) >= 8 {
+		return v, fmt.Errorf("too many dereferences")
+	}
+	v.rules = append(clone(v.rules), &ArgRule{From: Stack})
+	v.typ = ptr.Type
+	return v, nil
+}
+
+// assert follows the data pointer of the interface as the concrete type,
+// which is trusted without checking the type descriptor.
+func (v value) assert(typ godwarf.Type) (_ value, err error) {
+	if _, ok := resolveTypedef(v.typ).(*godwarf.InterfaceType); !ok {
+		return v, fmt.Errorf("value of type %s is not an interface", v.typ)
+	}
+	if v, err = v.field("data"); err != nil {
+		return
+	}
+	switch resolveTypedef(typ).(type) {
+	case *godwarf.PtrType, *godwarf.MapType, *godwarf.ChanType, *godwarf.FuncType:
+		// pointer shaped values are stored in the data word directly
+		v.typ = typ
+		return v, nil
+	}
+	v.typ = &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8}, Type: typ}
+	return v.deref()
+}
+
+func (v value) field(name string) (_ value, err error) {
+	structType := asStruct(v.typ)
+	if structType == nil {
+		return v, fmt.Errorf("cannot select %s of type %s", name, v.typ)
+	}
+	var field *godwarf.StructField
+	for _, f := range structType.Field {
+		if f.Name == name {
+			field = f
+			break
+		}
+	}
+	if field == nil {
+		return v, fmt.Errorf("type %s has no field %s", v.typ, name)
+	}
+	offset, size := field.ByteOffset, field.Type.Size()
+
+	if v.rules == nil && len(v.pieces) > 1 {
+		// select the pieces covering the field
+		pieces := []elf.Piece{}
+		start := int64(0)
+		for _, piece := range v.pieces {
+			end := start + piece.Size
+			if start < offset+size && offset < end {
+				if piece.Register < 0 {
+					piece.Offset += max(offset-start, 0)
+					piece.Size = min(end, offset+size) - max(start, offset)
+				} else if (start < offset || end > offset+size) && (start != offset || size > piece.Size) {
+					return v, fmt.Errorf("field %s is in the middle of a register", name)
+				}
+				pieces = append(pieces, piece)
+			}
+			start = end
+		}
+		v.typ, v.pieces = field.Type, pieces
+		return v, nil
+	}
+	if v.rules == nil {
+		if v, err = v.single(); err != nil {
+			return
+		}
+	}
+
+	v.rules = clone(v.rules)
+	last := v.rules[len(v.rules)-1]
+	if last.From == Register {
+		if offset != 0 {
+			return v, fmt.Errorf("field %s is in the middle of a register", name)
+		}
+	} else if last.Offset += offset; last.Offset > math.MaxInt16 {
+		return v, fmt.Errorf("offset of field %s is too large", name)
+	}
+	v.typ = field.Type
+	return v, nil
+}
+
+func resolveTypedef(typ godwarf.Type) godwarf.Type {
+	for {
+		typedef, ok := typ.(*godwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = typedef.Type
+	}
+}
+
+func asStruct(typ godwarf.Type) *godwarf.StructType {
+	switch typ := resolveTypedef(typ).(type) {
+	case *godwarf.StructType:
+		return typ
+	case *godwarf.StringType:
+		return &typ.StructType
+	case *godwarf.SliceType:
+		return &typ.StructType
+	case *godwarf.InterfaceType:
+		return asStruct(typ.Type)
+	}
+	return nil
+}
+
+// clone copies the rules, so that the values derived from the same value
+// never share the rules.
+func clone(rules []*ArgRule) (cloned []*ArgRule) {
+	for _, rule := range rules {
+		r := *rule
+		cloned = append(cloned, &r)
+	}
+	return
+}
@@ -3,16 +3,45 @@ package uprobe
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/jschwinger233/gofuncgraph/elf"
 )
 
 type FetchArg struct {
-	Varname   string
-	Statement string
-	Type      string
-	Size      int
-	Rules     []*ArgRule
+	Varname    string
+	Statement  string
+	Type       string
+	Elem       string              // type of the elements of slices
+	TypeName   string              // Go type of strings, slices, maps, interfaces and structs
+	Struct     *godwarf.StructType // layout of structs
+	Size       int
+	DataOffset int // offset in the payload of entry event
+	Fetches    []*ArgFetch
+	// for structs, the pointer fetched at AddrOffset plus AddrDelta is the
+	// address of the struct unless AddrOffset is 0, and the _type of the
+	// iface fields are fetched at the offsets in Types by the field offsets
+	AddrOffset int
+	AddrDelta  int64
+	Types      map[int64]int
+}
+
+// ArgFetch is a read by bpf, whose result is put at Offset of the data of
+// the arg.
+type ArgFetch struct {
+	Rules  []*ArgRule
+	Offset int
+	Size   int
+	// the size read is limited by the length at LenOffset times ElemSize,
+	// unless ElemSize is 0
+	LenOffset int
+	ElemSize  int
 }
 
 type ArgLocation int
@@ -28,20 +57,86 @@ type ArgRule struct {
 	Offset   int64
 }
 
-func parseFetchArgs(fetch map[string]map[string]string) (fetchArgs map[string][]*FetchArg, err error) {
+// FetchStatement is an arg to fetch, in the order given by the user.
+type FetchStatement struct {
+	Varname   string
+	Statement string
+}
+
+// parseFetchArgs compiles the statements at the entry, or at the RET if
+// atRet, where the statements are evaluated on the results.
+func parseFetchArgs(e *elf.ELF, fetch map[string][]FetchStatement, atRet bool) (fetchArgs map[string][]*FetchArg, err error) {
 	fetchArgs = map[string][]*FetchArg{}
-	for funcname, fet := range fetch {
-		for name, statement := range fet {
-			fa, err := newFetchArg(name, statement)
+	for funcname, statements := range fetch {
+		offset := 0
+		// nextResult is the index in elf.ABIIntRegisters of the result
+		// following the previous statement
+		nextResult := 0
+		var params []elf.Param
+		// Go expressions are resolved by the DWARF of the function
+		loadParams := func() (err error) {
+			if params != nil {
+				return
+			}
+			if atRet {
+				params, err = e.FuncResults(funcname)
+			} else {
+				params, err = e.FuncParams(funcname)
+			}
+			return
+		}
+		for _, stmt := range statements {
+			var fa *FetchArg
+			expr, kind, typed := cutLast(stmt.Statement, ":")
+			switch {
+			case typed && kind == "struct":
+				if err = loadParams(); err == nil {
+					fa, err = newStructFetchArg(e, stmt.Varname, stmt.Statement, expr, "", params)
+				}
+			case typed && (kind == "iface" || kind == "eface"):
+				if fa, err = newRawIfaceFetchArg(stmt.Varname, stmt.Statement, expr, kind); err == nil && atRet {
+					nextResult = resultIndex(fa, nextResult)
+				}
+			case typed && !isRawKind(kind):
+				fa, err = newStructFetchArg(e, stmt.Varname, stmt.Statement, expr, kind, nil)
+			case typed:
+				if fa, err = newFetchArg(stmt.Varname, stmt.Statement); err == nil && atRet {
+					nextResult = resultIndex(fa, nextResult)
+				}
+			case atRet && resultKinds[stmt.Statement] > 0:
+				fa, err = newResultFetchArg(stmt.Varname, stmt.Statement, &nextResult)
+			default:
+				if err = loadParams(); err == nil {
+					fa, err = newExprFetchArg(e, stmt.Varname, stmt.Statement, params)
+				}
+			}
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%s: %w", funcname, err)
 			}
+			fa.DataOffset = offset
+			offset += fa.Size
 			fetchArgs[funcname] = append(fetchArgs[funcname], fa)
 		}
 	}
 	return
 }
 
+// isRawKind tells whether the kind is of the raw rules, such as u64 or c128.
+func isRawKind(kind string) bool {
+	if len(kind) < 2 || !strings.ContainsRune("usc", rune(kind[0])) {
+		return false
+	}
+	_, err := strconv.Atoi(kind[1:])
+	return err == nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
 func newFetchArg(varname, statement string) (_ *FetchArg, err error) {
 	parts := strings.Split(statement, ":")
 	if len(parts) != 2 {
@@ -78,10 +173,27 @@ func newFetchArg(varname, statement string) (_ *FetchArg, err error) {
 	}
 	targetSize /= 8
 
+	rules, err := parseRules(parts[0])
+	if err != nil {
+		return
+	}
+
+	return &FetchArg{
+		Varname:   varname,
+		Statement: statement,
+		Size:      targetSize,
+		Type:      parts[1],
+		Fetches:   []*ArgFetch{{Rules: rules, Size: targetSize}},
+	}, nil
+}
+
+// parseRules parses the raw rules such as +8(%rsp), whose innermost
+// register comes first.
+func parseRules(s string) (_ []*ArgRule, err error) {
 	rules := []*ArgRule{}
 	buf := []byte{}
-	for i := 0; i < len(parts[0]); i++ {
-		if parts[0][i] == '(' || parts[0][i] == ')' && len(buf) > 0 {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '(' || s[i] == ')' && len(buf) > 0 {
 			op, err := newFetchOp(string(buf))
 			if err != nil {
 				return nil, err
@@ -90,8 +202,8 @@ func newFetchArg(varname, statement string) (_ *FetchArg, err error) {
 			buf = []byte{}
 			continue
 		}
-		if parts[0][i] != '(' && parts[0][i] != ')' {
-			buf = append(buf, parts[0][i])
+		if s[i] != '(' && s[i] != ')' {
+			buf = append(buf, s[i])
 		}
 	}
 	if len(buf) > 0 {
@@ -107,13 +219,46 @@ func newFetchArg(varname, statement string) (_ *FetchArg, err error) {
 		rules[i], rules[j] = rules[j], rules[i]
 	}
 
-	return &FetchArg{
-		Varname:   varname,
-		Statement: statement,
-		Size:      targetSize,
-		Type:      parts[1],
-		Rules:     rules,
-	}, nil
+	return rules, nil
+}
+
+// newRawIfaceFetchArg compiles the interface of kind iface or eface at the
+// raw rules expr. An interface in a register takes the next register in the
+// ABI order as its data pointer, such as ax and bx of %ax:iface.
+func newRawIfaceFetchArg(varname, statement, expr, kind string) (_ *FetchArg, err error) {
+	rules, err := parseRules(expr)
+	if err != nil {
+		return
+	}
+	word := &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8}, Type: &godwarf.VoidType{}}
+	typeField := "tab"
+	if kind == "eface" {
+		typeField = "_type"
+	}
+	layout := &godwarf.StructType{
+		CommonType: godwarf.CommonType{ByteSize: 16},
+		StructName: "runtime." + kind,
+		Kind:       "struct",
+		Field: []*godwarf.StructField{
+			{Name: typeField, Type: word, ByteOffset: 0},
+			{Name: "data", Type: word, ByteOffset: 8},
+		},
+	}
+	v := value{typ: &godwarf.InterfaceType{TypedefType: godwarf.TypedefType{CommonType: godwarf.CommonType{ByteSize: 16, Name: kind}, Type: layout}}}
+	if last := rules[len(rules)-1]; len(rules) > 1 || last.From == Stack {
+		v.rules = rules
+	} else {
+		i := slices.IndexFunc(elf.ABIIntRegisters, func(reg int) bool { return registers[reg] == last.Register })
+		if i < 0 || i+1 == len(elf.ABIIntRegisters) {
+			return nil, fmt.Errorf("%s: no register after %%%s for the data of %s", varname, last.Register, kind)
+		}
+		v.pieces = []elf.Piece{{Size: 8, Register: elf.ABIIntRegisters[i]}, {Size: 8, Register: elf.ABIIntRegisters[i+1]}}
+	}
+	fa := &FetchArg{Varname: varname, Statement: statement, TypeName: kind}
+	if err = v.compile(fa); err != nil {
+		return nil, fmt.Errorf("%s: %w", expr, err)
+	}
+	return fa, nil
 }
 
 func newFetchOp(op string) (_ *ArgRule, err error) {
@@ -142,6 +287,40 @@ func newFetchOp(op string) (_ *ArgRule, err error) {
 func (f *FetchArg) SprintValue(data []uint8) (value string) {
 	data = data[:f.Size]
 	switch f.Type {
+	case "string":
+		n, truncated := f.dataLen(data)
+		value = strconv.Quote(string(data[8 : 8+n]))
+		if truncated {
+			value += fmt.Sprintf("...(len=%d)", binary.LittleEndian.Uint64(data))
+		}
+	case "bytes":
+		n, truncated := f.dataLen(data)
+		if bytes := data[16 : 16+n]; utf8.Valid(bytes) && isPrint(string(bytes)) {
+			value = fmt.Sprintf("%s(%s)", f.TypeName, strconv.Quote(string(bytes)))
+		} else {
+			value = fmt.Sprintf("%s(0x%x)", f.TypeName, bytes)
+		}
+		if truncated {
+			value += "..."
+		}
+		value += fmt.Sprintf("(len=%d cap=%d)", binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:]))
+	case "slice":
+		n, truncated := f.dataLen(data)
+		elem := &FetchArg{Type: f.Elem, Size: f.Fetches[2].ElemSize}
+		elems := []string{}
+		for i := 16; i+elem.Size <= 16+n; i += elem.Size {
+			elems = append(elems, elem.SprintValue(data[i:]))
+		}
+		if truncated {
+			elems = append(elems, "...")
+		}
+		value = fmt.Sprintf("%s{%s}(len=%d cap=%d)", f.TypeName, strings.Join(elems, ", "), binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:]))
+	case "map":
+		value = fmt.Sprintf("%s(len=%d)", f.TypeName, binary.LittleEndian.Uint64(data))
+	case "struct":
+		value = f.SprintStruct(data, typeAddress)
+	case "iface", "eface":
+		value = f.SprintIface(data, typeAddress(binary.LittleEndian.Uint64(data)))
 	case "u8":
 		value = fmt.Sprintf("%d", data[0])
 	case "u16":
@@ -159,11 +338,50 @@ func (f *FetchArg) SprintValue(data []uint8) (value string) {
 	case "s64":
 		value = fmt.Sprintf("%d", int64(binary.LittleEndian.Uint64(data)))
 	case "f32":
-		value = fmt.Sprintf("%f", float32(binary.LittleEndian.Uint32(data)))
+		value = fmt.Sprintf("%f", math.Float32frombits(binary.LittleEndian.Uint32(data)))
 	case "f64":
-		value = fmt.Sprintf("%f", float64(binary.LittleEndian.Uint64(data)))
+		value = fmt.Sprintf("%f", math.Float64frombits(binary.LittleEndian.Uint64(data)))
+	case "bool":
+		value = strconv.FormatBool(data[0] != 0)
+	case "ptr":
+		value = fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(data))
 	case "c8", "c16", "c32", "c64", "c128", "c256", "c512":
 		value = string(data[:f.Size])
 	}
 	return
 }
+
+// SprintIface formats the interface with the name of its dynamic type, which
+// is resolved by the caller from the type descriptor in data.
+func (f *FetchArg) SprintIface(data []uint8, typeName string) string {
+	if binary.LittleEndian.Uint64(data) == 0 {
+		return fmt.Sprintf("%s(nil)", f.TypeName)
+	}
+	return fmt.Sprintf("%s(%s 0x%x)", f.TypeName, typeName, binary.LittleEndian.Uint64(data[8:]))
+}
+
+// typeAddress names the type by the address of its type descriptor, which is
+// resolved by the caller with the binary.
+func typeAddress(typ uint64) string {
+	return fmt.Sprintf("type@0x%x", typ)
+}
+
+// dataLen returns the size of the data read after the length, and whether
+// it is truncated.
+func (f *FetchArg) dataLen(data []uint8) (n int, truncated bool) {
+	fetch := f.Fetches[len(f.Fetches)-1]
+	length := binary.LittleEndian.Uint64(data) * uint64(fetch.ElemSize)
+	if length > uint64(fetch.Size) {
+		return fetch.Size, true
+	}
+	return int(length), false
+}
+
+func isPrint(s string) bool {
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
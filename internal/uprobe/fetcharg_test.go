@@ -0,0 +1,86 @@
+package uprobe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestParseRawIfaceFetchArgs checks the interfaces typed on the raw rules,
+// which need no DWARF.
+func TestParseRawIfaceFetchArgs(t *testing.T) {
+	reg := func(r string) *ArgRule { return &ArgRule{From: Register, Register: r} }
+	mem := func(offset int64) *ArgRule { return &ArgRule{From: Stack, Offset: offset} }
+	for _, tt := range []struct {
+		statements []FetchStatement
+		atRet      bool
+		types      []string
+		fetches    [][]*ArgFetch
+		err        bool
+	}{
+		{
+			statements: []FetchStatement{{Varname: "err", Statement: "%ax:iface"}},
+			types:      []string{"iface"},
+			fetches: [][]*ArgFetch{{
+				{Rules: []*ArgRule{reg("ax"), mem(8)}, Size: 8},
+				{Rules: []*ArgRule{reg("bx")}, Offset: 8, Size: 8},
+			}},
+		},
+		{
+			statements: []FetchStatement{{Varname: "v", Statement: "%di:eface"}},
+			types:      []string{"eface"},
+			fetches: [][]*ArgFetch{{
+				{Rules: []*ArgRule{reg("di")}, Size: 8},
+				{Rules: []*ArgRule{reg("si")}, Offset: 8, Size: 8},
+			}},
+		},
+		{
+			statements: []FetchStatement{{Varname: "err", Statement: "+16(%sp):iface"}},
+			types:      []string{"iface"},
+			fetches: [][]*ArgFetch{{
+				{Rules: []*ArgRule{reg("sp"), mem(16), mem(8)}, Size: 8},
+				{Rules: []*ArgRule{reg("sp"), mem(24)}, Offset: 8, Size: 8},
+			}},
+		},
+		{
+			// the result after %ax:eface is in cx
+			statements: []FetchStatement{{Varname: "v", Statement: "%ax:eface"}, {Varname: "n", Statement: "u64"}},
+			atRet:      true,
+			types:      []string{"eface", "u64"},
+			fetches: [][]*ArgFetch{
+				{{Rules: []*ArgRule{reg("ax")}, Size: 8}, {Rules: []*ArgRule{reg("bx")}, Offset: 8, Size: 8}},
+				{{Rules: []*ArgRule{reg("cx")}, Size: 8}},
+			},
+		},
+		{statements: []FetchStatement{{Varname: "err", Statement: "%r11:iface"}}, err: true},
+		{statements: []FetchStatement{{Varname: "err", Statement: "%dx:iface"}}, err: true},
+	} {
+		fetchArgs, err := parseFetchArgs(nil, map[string][]FetchStatement{"main.f": tt.statements}, tt.atRet)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseFetchArgs(%+v): got no error", tt.statements)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFetchArgs(%+v): %v", tt.statements, err)
+			continue
+		}
+		for i, fa := range fetchArgs["main.f"] {
+			if fa.Type != tt.types[i] || !reflect.DeepEqual(fa.Fetches, tt.fetches[i]) {
+				t.Errorf("parseFetchArgs(%+v): got %s fetching %s, want %s fetching %s", tt.statements, fa.Type, sprintFetches(fa.Fetches), tt.types[i], sprintFetches(tt.fetches[i]))
+			}
+		}
+	}
+}
+
+func sprintFetches(fetches []*ArgFetch) (s string) {
+	for _, fetch := range fetches {
+		s += "["
+		for _, rule := range fetch.Rules {
+			s += fmt.Sprintf("%+v", *rule)
+		}
+		s += fmt.Sprintf(" @%d+%d]", fetch.Offset, fetch.Size)
+	}
+	return
+}
@@ -15,11 +15,20 @@ type ParseOptions struct {
 	ExcludeVendor   bool
 	UprobeWildcards []string
 	OutputWildcards []string
-	Fetch           map[string]map[string]string // funcname: varname: expression
+	Fetch           map[string][]FetchStatement // funcname: statements at entry
+	RetFetch        map[string][]FetchStatement // funcname: statements at RET
+	// Stat attaches no runtime uprobes, which only serve the call trees
+	Stat bool
+	// Sched attaches the uprobes on parking and readying goroutines
+	Sched bool
 }
 
 func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
-	fetchArgs, err := parseFetchArgs(opts.Fetch)
+	fetchArgs, err := parseFetchArgs(elf, opts.Fetch, false)
+	if err != nil {
+		return
+	}
+	retFetchArgs, err := parseFetchArgs(elf, opts.RetFetch, true)
 	if err != nil {
 		return
 	}
@@ -29,21 +38,30 @@ func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
 		return
 	}
 
+	attachWildcards := []*Wildcard{}
+	for _, wc := range append(opts.UprobeWildcards, opts.OutputWildcards...) {
+		attachWildcards = append(attachWildcards, CompileWildcard(wc))
+	}
+	outputWildcards := []*Wildcard{}
+	for _, wc := range opts.OutputWildcards {
+		outputWildcards = append(outputWildcards, CompileWildcard(wc))
+	}
+
 	wantedFuncs := map[string]interface{}{}
 	attachFuncs := []string{}
 	for _, symbol := range symbols {
 		if debugelf.ST_TYPE(symbol.Info) == debugelf.STT_FUNC {
-			for _, wc := range append(opts.UprobeWildcards, opts.OutputWildcards...) {
-				if MatchWildcard(wc, symbol.Name) {
+			for _, wc := range attachWildcards {
+				if wc.Match(symbol.Name) {
 					if opts.ExcludeVendor && strings.Contains(symbol.Name, "/vendor/") {
 						continue
 					}
 					attachFuncs = append(attachFuncs, symbol.Name)
-					if len(opts.OutputWildcards) == 0 {
+					if len(outputWildcards) == 0 {
 						wantedFuncs[symbol.Name] = true
 					} else {
-						for _, wc := range opts.OutputWildcards {
-							if MatchWildcard(wc, symbol.Name) {
+						for _, wc := range outputWildcards {
+							if wc.Match(symbol.Name) {
 								wantedFuncs[symbol.Name] = true
 								break
 							}
@@ -56,20 +74,16 @@ func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
 		}
 	}
 
-	sym, err := elf.ResolveSymbol("runtime.goexit1")
-	if err != nil {
-		return nil, err
+	if !opts.Stat {
+		if uprobes, err = runtimeUprobes(elf, opts.Sched); err != nil {
+			return
+		}
 	}
-	entOffset, err := elf.FuncOffset("runtime.goexit1")
+
+	funcsRetOffsets, funcsErrs, err := elf.FuncsRetOffsets(attachFuncs)
 	if err != nil {
-		return nil, err
+		return
 	}
-	uprobes = append(uprobes, Uprobe{
-		Funcname:  "runtime.goexit1",
-		Location:  AtGoroutineExit,
-		Address:   sym.Value,
-		AbsOffset: entOffset,
-	})
 
 	for _, funcname := range attachFuncs {
 		message := &bytes.Buffer{}
@@ -94,7 +108,7 @@ func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
 			Wanted:    wanted,
 		})
 
-		retOffsets, err := elf.FuncRetOffsets(funcname)
+		retOffsets, err := funcsRetOffsets[funcname], funcsErrs[funcname]
 		if err == nil && len(retOffsets) == 0 {
 			err = errors.New("no ret offsets")
 		}
@@ -109,8 +123,10 @@ func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
 			uprobes = append(uprobes, Uprobe{
 				Funcname:  funcname,
 				Location:  AtRet,
+				Address:   sym.Value + retOffset - entOffset,
 				AbsOffset: retOffset,
 				RelOffset: retOffset - entOffset,
+				FetchArgs: retFetchArgs[funcname],
 			})
 		}
 		fmt.Fprintf(message, "]")
@@ -122,3 +138,70 @@ func Parse(elf *elf.ELF, opts *ParseOptions) (uprobes []Uprobe, err error) {
 	}
 	return
 }
+
+// runtimeUprobes returns the uprobes on runtime functions to follow the
+// lifecycle of goroutines, panics and stack copies, and the scheduling of
+// goroutines if sched. Missing runtime functions are skipped.
+func runtimeUprobes(elf *elf.ELF, sched bool) (uprobes []Uprobe, err error) {
+	entries := map[string]UprobeLocation{
+		"runtime.goexit1":   AtGoroutineExit,
+		"runtime.newproc1":  AtNewproc,
+		"runtime.gopanic":   AtPanic,
+		"runtime.gorecover": AtRecover,
+		"runtime.recovery":  AtRecovery,
+		"runtime.copystack": AtCopystack,
+	}
+	rets := map[string]UprobeLocation{
+		"runtime.newproc1": AtNewprocRet,
+	}
+	if sched {
+		entries["runtime.gopark"] = AtGopark
+		entries["runtime.ready"] = AtGoroutineReady
+		rets["runtime.gopark"] = AtGoparkRet
+	}
+
+	for funcname, location := range entries {
+		sym, err := elf.ResolveSymbol(funcname)
+		if err != nil {
+			log.Warnf("skip uprobe on %s: %v", funcname, err)
+			continue
+		}
+		entOffset, err := elf.FuncOffset(funcname)
+		if err != nil {
+			return nil, err
+		}
+		uprobes = append(uprobes, Uprobe{
+			Funcname:  funcname,
+			Location:  location,
+			Address:   sym.Value,
+			AbsOffset: entOffset,
+		})
+	}
+
+	for funcname, location := range rets {
+		sym, err := elf.ResolveSymbol(funcname)
+		if err != nil {
+			log.Warnf("skip uprobe on RETs of %s: %v", funcname, err)
+			continue
+		}
+		retOffsets, err := elf.FuncRetOffsets(funcname)
+		if err != nil {
+			log.Warnf("skip uprobe on RETs of %s: %v", funcname, err)
+			continue
+		}
+		entOffset, err := elf.FuncOffset(funcname)
+		if err != nil {
+			return nil, err
+		}
+		for _, retOffset := range retOffsets {
+			uprobes = append(uprobes, Uprobe{
+				Funcname:  funcname,
+				Location:  location,
+				Address:   sym.Value + retOffset - entOffset,
+				AbsOffset: retOffset,
+				RelOffset: retOffset - entOffset,
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package uprobe
+
+import (
+	"fmt"
+
+	"github.com/jschwinger233/gofuncgraph/elf"
+)
+
+// resultKinds are the types of the results fetched by the register ABI
+// without DWARF, with the number of integer registers they take.
+var resultKinds = map[string]int{
+	"u8": 1, "u16": 1, "u32": 1, "u64": 1,
+	"s8": 1, "s16": 1, "s32": 1, "s64": 1,
+	"bool": 1, "ptr": 1,
+	"string": 2, "iface": 2, "eface": 2,
+}
+
+// newResultFetchArg fetches the result of kind from the registers at
+// *next in the result order of the register ABI, and advances *next.
+func newResultFetchArg(varname, kind string, next *int) (_ *FetchArg, err error) {
+	n := resultKinds[kind]
+	if *next+n > len(elf.ABIIntRegisters) {
+		return nil, fmt.Errorf("%s: results exceed %d registers", varname, len(elf.ABIIntRegisters))
+	}
+	regs := []*ArgRule{}
+	for _, reg := range elf.ABIIntRegisters[*next : *next+n] {
+		regs = append(regs, &ArgRule{From: Register, Register: registers[reg]})
+	}
+	*next += n
+
+	fa := &FetchArg{Varname: varname, Statement: kind, Type: kind, TypeName: kind}
+	switch kind {
+	case "string":
+		fa.Fetches = []*ArgFetch{
+			{Rules: regs[1:], Size: 8},
+			{Rules: []*ArgRule{regs[0], {From: Stack}}, Offset: 8, Size: maxDataSize, ElemSize: 1},
+		}
+	case "iface":
+		// _type is right after inter in runtime.itab
+		fa.Fetches = []*ArgFetch{
+			{Rules: []*ArgRule{regs[0], {From: Stack, Offset: 8}}, Size: 8},
+			{Rules: regs[1:], Offset: 8, Size: 8},
+		}
+	case "eface":
+		fa.Fetches = []*ArgFetch{{Rules: regs[:1], Size: 8}, {Rules: regs[1:], Offset: 8, Size: 8}}
+	case "bool", "u8", "s8":
+		fa.Fetches = []*ArgFetch{{Rules: regs, Size: 1}}
+	case "u16", "s16":
+		fa.Fetches = []*ArgFetch{{Rules: regs, Size: 2}}
+	case "u32", "s32":
+		fa.Fetches = []*ArgFetch{{Rules: regs, Size: 4}}
+	default:
+		fa.Fetches = []*ArgFetch{{Rules: regs, Size: 8}}
+	}
+	for _, fetch := range fa.Fetches {
+		fa.Size = max(fa.Size, fetch.Offset+fetch.Size)
+	}
+	return fa, nil
+}
+
+// resultIndex returns the index of the result after the last one fetched by
+// the raw rules if it's a result register, such as %ax:s64, or next otherwise.
+func resultIndex(fa *FetchArg, next int) int {
+	rules := fa.Fetches[len(fa.Fetches)-1].Rules
+	if len(rules) != 1 || rules[0].From != Register {
+		return next
+	}
+	for i, reg := range elf.ABIIntRegisters {
+		if registers[reg] == rules[0].Register {
+			return i + 1
+		}
+	}
+	return next
+}
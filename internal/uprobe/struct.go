@@ -0,0 +1,261 @@
+package uprobe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go/parser"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/jschwinger233/gofuncgraph/elf"
+)
+
+// A struct is copied in chunks of maxDataSize up to maxStructSize bytes,
+// followed by its address if it has nested structs, the data of its first
+// maxStructStrings string fields and the _type of its iface fields, in up to
+// maxStructFetches of the 8 fetches of a uprobe.
+const (
+	maxStructSize       = 192
+	maxStructStrings    = 3
+	maxStructStringSize = 32
+	maxStructFetches    = 6
+)
+
+// newStructFetchArg copies the struct of the Go expression expr if typeName
+// is empty, or of the raw rules expr typed as typeName otherwise, such as
+// %bx:*net/http.Request. Pointers to structs are followed.
+func newStructFetchArg(e *elf.ELF, varname, statement, expr, typeName string, params []elf.Param) (_ *FetchArg, err error) {
+	var v value
+	if typeName == "" {
+		node, err := parser.ParseExpr(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %s: %w", expr, err)
+		}
+		if v, err = evalExpr(e, node, params); err != nil {
+			return nil, fmt.Errorf("%s: %w", expr, err)
+		}
+	} else {
+		if v.rules, err = parseRules(expr); err != nil {
+			return
+		}
+		if v.typ, err = e.TypeByName(typeName); err != nil {
+			return
+		}
+	}
+	if _, ok := resolveTypedef(v.typ).(*godwarf.PtrType); ok {
+		if v, err = v.deref(); err != nil {
+			return nil, fmt.Errorf("%s: %w", expr, err)
+		}
+	}
+	structType, ok := resolveTypedef(v.typ).(*godwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s: type %s is not a struct", expr, v.typ)
+	}
+	fa := &FetchArg{Varname: varname, Statement: statement, Type: "struct", TypeName: v.typ.String(), Struct: structType}
+	if err = v.copyStruct(fa); err != nil {
+		return nil, fmt.Errorf("%s: %w", expr, err)
+	}
+	for _, fetch := range fa.Fetches {
+		fa.Size = max(fa.Size, fetch.Offset+fetch.Size)
+	}
+	return fa, nil
+}
+
+// copyStruct fetches the bytes of the struct, either from memory or from its
+// pieces, then its address, the data of the string fields limited by their
+// lengths and the _type of the iface fields.
+func (v value) copyStruct(fa *FetchArg) (err error) {
+	size := min(v.typ.Size(), maxStructSize)
+	// the address is fetched by addr off by delta
+	var addr []*ArgRule
+	var delta int64
+	if v.rules == nil {
+		offset := int64(0)
+		for _, piece := range v.pieces {
+			p, err := value{typ: v.typ, pieces: []elf.Piece{piece}}.single()
+			if err != nil {
+				return err
+			}
+			for chunk := int64(0); chunk < piece.Size && offset+chunk < size; chunk += maxDataSize {
+				rules := clone(p.rules)
+				rules[len(rules)-1].Offset += chunk
+				fa.Fetches = append(fa.Fetches, &ArgFetch{Rules: rules, Offset: int(offset + chunk), Size: int(min(maxDataSize, piece.Size-chunk, size-offset-chunk))})
+			}
+			offset += piece.Size
+		}
+		if len(v.pieces) == 1 && v.pieces[0].Register < 0 {
+			addr, delta = []*ArgRule{{From: Register, Register: "sp"}}, v.pieces[0].Offset
+		}
+	} else if v.rules[len(v.rules)-1].From == Register {
+		return fmt.Errorf("struct of type %s is not in memory", v.typ)
+	} else {
+		for offset := int64(0); offset < size; offset += maxDataSize {
+			rules := clone(v.rules)
+			if rules[len(rules)-1].Offset += offset; rules[len(rules)-1].Offset > math.MaxInt16 {
+				return fmt.Errorf("offset of struct is too large")
+			}
+			fa.Fetches = append(fa.Fetches, &ArgFetch{Rules: rules, Offset: int(offset), Size: int(min(maxDataSize, size-offset))})
+		}
+		addr, delta = clone(v.rules[:len(v.rules)-1]), v.rules[len(v.rules)-1].Offset
+	}
+	if len(fa.Fetches) > maxStructFetches {
+		return fmt.Errorf("struct of type %s takes %d fetches to copy, more than %d of the 8 fetches of a uprobe", v.typ, len(fa.Fetches), maxStructFetches)
+	}
+
+	dataOffset, strs := int((size+7)/8*8), 0
+	if addr != nil && slices.ContainsFunc(fa.Struct.Field, func(field *godwarf.StructField) bool {
+		return field.ByteOffset+field.Type.Size() <= size && isNested(field.Type)
+	}) {
+		fa.Fetches = append(fa.Fetches, &ArgFetch{Rules: addr, Offset: dataOffset, Size: 8})
+		fa.AddrOffset, fa.AddrDelta = dataOffset, delta
+		dataOffset += 8
+	}
+	fa.Types = map[int64]int{}
+	for _, field := range fa.Struct.Field {
+		if len(fa.Fetches) == maxStructFetches {
+			break
+		}
+		if _, ok := resolveTypedef(field.Type).(*godwarf.StringType); ok && strs < maxStructStrings && field.ByteOffset+16 <= size {
+			str, err := v.field(field.Name)
+			if err != nil {
+				return err
+			}
+			ptr, err := str.field("str")
+			if err == nil && ptr.rules == nil {
+				ptr, err = ptr.single()
+			}
+			if err != nil {
+				return err
+			}
+			data, err := ptr.deref()
+			if err != nil {
+				return err
+			}
+			fa.Fetches = append(fa.Fetches, &ArgFetch{
+				Rules:     data.rules,
+				Offset:    dataOffset,
+				Size:      maxStructStringSize,
+				LenOffset: int(field.ByteOffset + 8),
+				ElemSize:  1,
+			})
+			dataOffset += maxStructStringSize
+			strs++
+		}
+	}
+	// the strings take precedence over the dynamic types of interfaces
+	for _, field := range fa.Struct.Field {
+		if len(fa.Fetches) == maxStructFetches {
+			break
+		}
+		if _, ok := resolveTypedef(field.Type).(*godwarf.InterfaceType); ok && field.ByteOffset+16 <= size {
+			iface, err := v.field(field.Name)
+			if err != nil {
+				return err
+			}
+			if _, err := iface.field("_type"); err == nil {
+				// the _type of eface is copied with the struct
+				continue
+			}
+			tab, err := iface.field("tab")
+			if err == nil && tab.rules == nil {
+				tab, err = tab.single()
+			}
+			if err != nil {
+				return err
+			}
+			// _type is right after inter in runtime.itab
+			fa.Fetches = append(fa.Fetches, &ArgFetch{Rules: append(clone(tab.rules), &ArgRule{From: Stack, Offset: 8}), Offset: dataOffset, Size: 8})
+			fa.Types[field.ByteOffset] = dataOffset
+			dataOffset += 8
+		}
+	}
+	return
+}
+
+func isNested(typ godwarf.Type) bool {
+	switch resolveTypedef(typ).(type) {
+	case *godwarf.StructType, *godwarf.ArrayType:
+		return true
+	}
+	return false
+}
+
+// SprintStruct formats the fields copied by their names, resolving the
+// dynamic types of interfaces by typeName, while the nested structs and the
+// pointers are shown as addresses.
+func (f *FetchArg) SprintStruct(data []uint8, typeName func(typ uint64) string) string {
+	copied := min(f.Struct.Size(), maxStructSize)
+	fields := []string{}
+	for _, field := range f.Struct.Field {
+		if field.Type.Size() == 0 {
+			continue
+		}
+		if field.ByteOffset+field.Type.Size() > copied {
+			fields = append(fields, "...")
+			break
+		}
+		fields = append(fields, field.Name+":"+f.sprintField(field, data, typeName))
+	}
+	return fmt.Sprintf("%s{%s}", f.TypeName, strings.Join(fields, ", "))
+}
+
+func (f *FetchArg) sprintField(field *godwarf.StructField, data []uint8, typeName func(typ uint64) string) string {
+	value := data[field.ByteOffset:]
+	switch typ := resolveTypedef(field.Type).(type) {
+	case *godwarf.StringType:
+		length := binary.LittleEndian.Uint64(value[8:])
+		if length == 0 {
+			return `""`
+		}
+		for _, fetch := range f.Fetches {
+			if fetch.ElemSize != 1 || int64(fetch.LenOffset) != field.ByteOffset+8 {
+				continue
+			}
+			str := strconv.Quote(string(data[fetch.Offset : fetch.Offset+int(min(length, uint64(fetch.Size)))]))
+			if length > uint64(fetch.Size) {
+				str += fmt.Sprintf("...(len=%d)", length)
+			}
+			return str
+		}
+		return fmt.Sprintf("string(len=%d)", length)
+	case *godwarf.SliceType:
+		return fmt.Sprintf("%s(len=%d cap=%d)", typ, binary.LittleEndian.Uint64(value[8:]), binary.LittleEndian.Uint64(value[16:]))
+	case *godwarf.InterfaceType:
+		desc := binary.LittleEndian.Uint64(value)
+		if desc == 0 {
+			return "nil"
+		}
+		if offset, ok := f.Types[field.ByteOffset]; ok {
+			desc = binary.LittleEndian.Uint64(data[offset:])
+		} else if asStruct(typ).Field[0].Name != "_type" {
+			// the _type of iface is not fetched when the fetches run out
+			return sprintAddress(value[8:])
+		}
+		return fmt.Sprintf("%s(%s 0x%x)", field.Type, typeName(desc), binary.LittleEndian.Uint64(value[8:]))
+	case *godwarf.StructType, *godwarf.ArrayType:
+		if f.AddrOffset == 0 {
+			return "{...}"
+		}
+		return fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(data[f.AddrOffset:])+uint64(f.AddrDelta+field.ByteOffset))
+	case *godwarf.MapType:
+		return sprintAddress(value)
+	}
+	switch kind := scalarKind(resolveTypedef(field.Type)); kind {
+	case "":
+		return "?"
+	case "ptr":
+		return sprintAddress(value)
+	default:
+		return (&FetchArg{Type: kind, Size: int(field.Type.Size())}).SprintValue(value)
+	}
+}
+
+func sprintAddress(data []uint8) string {
+	if addr := binary.LittleEndian.Uint64(data); addr != 0 {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	return "nil"
+}
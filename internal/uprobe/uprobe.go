@@ -6,6 +6,15 @@ const (
 	AtEntry UprobeLocation = iota
 	AtRet
 	AtGoroutineExit
+	AtNewproc
+	AtNewprocRet
+	AtGopark
+	AtGoparkRet
+	AtGoroutineReady
+	AtPanic
+	AtRecover
+	AtRecovery
+	AtCopystack
 )
 
 type Uprobe struct {
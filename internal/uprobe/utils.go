@@ -1,29 +1,37 @@
 package uprobe
 
-func MatchWildcard(pattern, str string) bool {
-	if len(pattern) == 0 && len(str) == 0 {
-		return true
+import "strings"
+
+// Wildcard is a compiled pattern where * matches any sequence. The segments
+// between stars are matched at their leftmost occurrences, which never needs
+// backtracking.
+type Wildcard struct {
+	segments []string
+}
+
+func CompileWildcard(pattern string) *Wildcard {
+	return &Wildcard{segments: strings.Split(pattern, "*")}
+}
+
+func (w *Wildcard) Match(str string) bool {
+	segments := w.segments
+	if len(segments) == 1 {
+		return str == segments[0]
 	}
-	if len(pattern) == 0 {
+	if !strings.HasPrefix(str, segments[0]) {
 		return false
 	}
-	if len(str) == 0 {
-		for _, p := range pattern {
-			if p != '*' {
-				return false
-			}
+	str = str[len(segments[0]):]
+	for _, segment := range segments[1 : len(segments)-1] {
+		idx := strings.Index(str, segment)
+		if idx < 0 {
+			return false
 		}
-		return true
-	}
-
-	if pattern[0] == '*' {
-		for i := 0; i <= len(str); i++ {
-			if MatchWildcard(pattern[1:], str[i:]) {
-				return true
-			}
-		}
-		return false
+		str = str[idx+len(segment):]
 	}
+	return strings.HasSuffix(str, segments[len(segments)-1])
+}
 
-	return pattern[0] == str[0] && MatchWildcard(pattern[1:], str[1:])
+func MatchWildcard(pattern, str string) bool {
+	return CompileWildcard(pattern).Match(str)
 }
@@ -0,0 +1,108 @@
+package uprobe
+
+import "testing"
+
+// matchWildcard is the backtracking matcher replaced by Wildcard.
+func matchWildcard(pattern, str string) bool {
+	if len(pattern) == 0 && len(str) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if len(str) == 0 {
+		for _, p := range pattern {
+			if p != '*' {
+				return false
+			}
+		}
+		return true
+	}
+
+	if pattern[0] == '*' {
+		for i := 0; i <= len(str); i++ {
+			if matchWildcard(pattern[1:], str[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return pattern[0] == str[0] && matchWildcard(pattern[1:], str[1:])
+}
+
+func TestWildcard(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, str string
+		match        bool
+	}{
+		{"", "", true},
+		{"", "main.main", false},
+		{"*", "", true},
+		{"*", "main.main", true},
+		{"**", "", true},
+		{"main.main", "main.main", true},
+		{"main.main", "main.mainx", false},
+		{"main.*", "main.main", true},
+		{"main.*", "main.", true},
+		{"main.*", "main", false},
+		{"main.**", "main.handleBar", true},
+		{"*handleBar", "main.handleBar", true},
+		{"*handleBar", "main.handleBarx", false},
+		{"net/http*", "net/http.(*conn).serve", true},
+		{"net/http*", "net/url.Parse", false},
+		{"*http*serve*", "net/http.(*conn).serve", true},
+		{"*http*serve*", "net/http.(*Server).Serve", false},
+		{"*a*a", "aa", true},
+		{"*a*a", "a", false},
+		{"a*a", "a", false},
+		{"*ab*ab", "abab", true},
+		{"*ab*ab", "aabab", true},
+		{"*ab*ab", "abba", false},
+		{"*.(*conn).*", "net/http.(*conn).serve", true},
+		// ? is not special
+		{"main.?", "main.?", true},
+		{"main.?", "main.a", false},
+		{"*?", "main.a", false},
+		{"*?*", "main.a?b", true},
+		{"*/example/internal/log*", "github.com/jschwinger233/gofuncgraph/example/internal/log.Debug", true},
+	} {
+		if got := CompileWildcard(tt.pattern).Match(tt.str); got != tt.match {
+			t.Errorf("%q.Match(%q): got %v, want %v", tt.pattern, tt.str, got, tt.match)
+		}
+		if got := matchWildcard(tt.pattern, tt.str); got != tt.match {
+			t.Errorf("matchWildcard(%q, %q): got %v, want %v", tt.pattern, tt.str, got, tt.match)
+		}
+		if got := MatchWildcard(tt.pattern, tt.str); got != tt.match {
+			t.Errorf("MatchWildcard(%q, %q): got %v, want %v", tt.pattern, tt.str, got, tt.match)
+		}
+	}
+}
+
+// TestWildcardExhaustive compares Wildcard with the backtracking matcher on
+// all the short patterns and strings over a small alphabet.
+func TestWildcardExhaustive(t *testing.T) {
+	all := func(alphabet string, n int) (strs []string) {
+		strs = []string{""}
+		for prev := []string{""}; n > 0; n-- {
+			next := []string{}
+			for _, s := range prev {
+				for _, c := range alphabet {
+					next = append(next, s+string(c))
+				}
+			}
+			strs = append(strs, next...)
+			prev = next
+		}
+		return
+	}
+	strs := all("ab?", 5)
+	for _, pattern := range all("ab?*", 5) {
+		w := CompileWildcard(pattern)
+		for _, str := range strs {
+			if got, want := w.Match(str), matchWildcard(pattern, str); got != want {
+				t.Fatalf("%q.Match(%q): got %v, want %v", pattern, str, got, want)
+			}
+		}
+	}
+}
@@ -4,9 +4,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/jschwinger233/gofuncgraph/version"
 	log "github.com/sirupsen/logrus"
@@ -14,7 +16,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func init() {
+// setRlimit is called by main rather than init, so the tests of the package
+// run without privileges.
+func setRlimit() {
 	rlimit := syscall.Rlimit{
 		Cur: unix.RLIM_INFINITY,
 		Max: unix.RLIM_INFINITY,
@@ -31,7 +35,58 @@ func init() {
 	}
 }
 
+func traceFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "debug",
+			Value: false,
+			Usage: "enable debug logging",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-vendor",
+			Value: true,
+		},
+		&cli.StringSliceFlag{
+			Name: "uprobe-wildcards",
+		},
+		&cli.StringFlag{
+			Name:  "debug-dir",
+			Usage: "directory of the separate debug info, searched by build id, debuglink and file name",
+		},
+		&cli.StringFlag{
+			Name:  "go-version",
+			Usage: "Go version of the binary, e.g. go1.21, overriding the one in buildinfo",
+		},
+	}
+}
+
+func schedFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "sched",
+		Usage: "split the wall time of frames into on-cpu, runnable and blocked time by probing the scheduler",
+	}
+}
+
+func thresholdFlag() cli.Flag {
+	return &cli.DurationFlag{
+		Name:  "threshold",
+		Usage: "only print call trees whose root takes longer than threshold, e.g. 100ms; only the fast roots without other events are dropped in kernel",
+	}
+}
+
+func before(c *cli.Context) error {
+	if c.Bool("debug") {
+		log.SetLevel(log.DebugLevel)
+	}
+	if (c.Args().Present() || c.IsSet("pid")) && c.Args().First() != "help" && len(c.StringSlice("uprobe-wildcards")) == 0 {
+		return errors.New(`Required flag "uprobe-wildcards" not set`)
+	}
+	return nil
+}
+
 func main() {
+	setRlimit()
+
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Print(version.String())
 	}
@@ -39,46 +94,140 @@ func main() {
 	app := &cli.App{
 		Name: "gofun",
 		// TODO@zc: kernel version
-		Usage:     "bpf(2)-based ftrace(1)-like function graph tracer for Go! \n(only non-stripped non-PIE-built Golang ELF on x86-64 little-endian Linux is supported for now)",
-		UsageText: `See https://github.com/jschwinger233/gofuncgraph for usage examples`,
+		Usage:     "bpf(2)-based ftrace(1)-like function graph tracer for Go! \n(only Golang ELF on x86-64 little-endian Linux is supported for now)",
+		UsageText: "gofuncgraph [options] <binary> [wildcards...]\n   gofuncgraph [options] --pid <pid> [wildcards...]\n   gofuncgraph run [options] [wildcards...] -- <command> [args...]\n\nSee https://github.com/jschwinger233/gofuncgraph for usage examples",
 		Version:   version.VERSION,
-		Flags: []cli.Flag{
-			&cli.BoolFlag{
-				Name:  "debug",
-				Value: false,
-				Usage: "enable debug logging",
+		Flags: append(traceFlags(),
+			&cli.IntFlag{
+				Name:  "pid",
+				Usage: "only trace the running process of pid, the executable is found from /proc",
 			},
-			&cli.BoolFlag{
-				Name:  "exclude-vendor",
-				Value: true,
-			},
-			&cli.StringSliceFlag{
-				Name:     "uprobe-wildcards",
-				Required: true,
-			},
-		},
+			thresholdFlag(),
+			schedFlag(),
+		),
 		Before: func(c *cli.Context) error {
-			if c.Bool("debug") {
-				log.SetLevel(log.DebugLevel)
+			if c.Args().First() == "run" || c.Args().First() == "stat" {
+				return nil
 			}
-			return nil
+			return before(c)
 		},
 		Action: func(ctx *cli.Context) (err error) {
-			bin := ctx.Args().First()
-			args := ctx.Args().Tail()
-
-			if bin == "" || ctx.Bool("help") {
+			opts, ok := binaryOptions(ctx)
+			if !ok {
 				return cli.ShowAppHelp(ctx)
 			}
-
-			tracer, err := NewTracer(bin, ctx.Bool("exclude-vendor"), ctx.StringSlice("uprobe-wildcards"), args)
+			opts.Threshold = ctx.Duration("threshold")
+			opts.Sched = ctx.Bool("sched")
+			tracer, err := NewTracer(opts)
 			if err != nil {
 				return
 			}
 			return tracer.Start()
 		},
+		Commands: []*cli.Command{
+			{
+				Name:      "stat",
+				Usage:     "print latency histograms of functions aggregated in kernel",
+				UsageText: "gofuncgraph stat [options] <binary> [wildcards...]\n   gofuncgraph stat [options] --pid <pid> [wildcards...]",
+				Flags: append(traceFlags(),
+					&cli.IntFlag{
+						Name:  "pid",
+						Usage: "only trace the running process of pid, the executable is found from /proc",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Value: 5 * time.Second,
+						Usage: "interval of printing histograms",
+					},
+				),
+				Before: before,
+				Action: func(ctx *cli.Context) (err error) {
+					opts, ok := binaryOptions(ctx)
+					if !ok {
+						return cli.ShowCommandHelp(ctx, "stat")
+					}
+					opts.Stat = true
+					opts.StatInterval = ctx.Duration("interval")
+					tracer, err := NewTracer(opts)
+					if err != nil {
+						return
+					}
+					return tracer.Start()
+				},
+			},
+			{
+				Name:      "run",
+				Usage:     "launch a command and trace it from its very first instruction",
+				UsageText: "gofuncgraph run [options] [wildcards...] -- <command> [args...]",
+				Flags:     append(traceFlags(), thresholdFlag(), schedFlag()),
+				Before:    before,
+				Action: func(ctx *cli.Context) (err error) {
+					args, command := splitCommand(ctx.Args().Slice())
+					if len(command) == 0 {
+						return cli.ShowCommandHelp(ctx, "run")
+					}
+
+					tracer, err := NewTracer(TracerOptions{
+						Command:         command,
+						DebugDir:        ctx.String("debug-dir"),
+						GoVersion:       ctx.String("go-version"),
+						ExcludeVendor:   ctx.Bool("exclude-vendor"),
+						UprobeWildcards: ctx.StringSlice("uprobe-wildcards"),
+						Args:            args,
+						Threshold:       ctx.Duration("threshold"),
+						Sched:           ctx.Bool("sched"),
+					})
+					if err != nil {
+						return
+					}
+					if err = tracer.Start(); err != nil {
+						return
+					}
+					if code := tracer.ExitCode(); code != 0 {
+						return cli.Exit("", code)
+					}
+					return
+				},
+			},
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
+
+// binaryOptions returns the options to trace a binary or a running process.
+func binaryOptions(ctx *cli.Context) (opts TracerOptions, ok bool) {
+	pid := ctx.Int("pid")
+	bin := ctx.Args().First()
+	args := ctx.Args().Tail()
+	if pid != 0 {
+		bin = ""
+		args = ctx.Args().Slice()
+	}
+
+	if bin == "" && pid == 0 || ctx.Bool("help") {
+		return
+	}
+
+	return TracerOptions{
+		Bin:             bin,
+		DebugDir:        ctx.String("debug-dir"),
+		GoVersion:       ctx.String("go-version"),
+		Pid:             pid,
+		ExcludeVendor:   ctx.Bool("exclude-vendor"),
+		UprobeWildcards: ctx.StringSlice("uprobe-wildcards"),
+		Args:            args,
+	}, true
+}
+
+// splitCommand splits the arguments of run into wildcards and the command,
+// flag parsing already consumed the "--" if no wildcard is given.
+func splitCommand(args []string) (wildcards, command []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return nil, args
+}
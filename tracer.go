@@ -5,13 +5,17 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/signal"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jschwinger233/gofuncgraph/elf"
 	"github.com/jschwinger233/gofuncgraph/internal/bpf"
 	"github.com/jschwinger233/gofuncgraph/internal/eventmanager"
+	"github.com/jschwinger233/gofuncgraph/internal/proc"
+	"github.com/jschwinger233/gofuncgraph/internal/stat"
 	"github.com/jschwinger233/gofuncgraph/internal/uprobe"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -25,78 +29,162 @@ func init() {
 	OffsetPattern = regexp.MustCompile(`\+\d+$`)
 }
 
+type TracerOptions struct {
+	Bin             string
+	DebugDir        string
+	GoVersion       string
+	Pid             int
+	Command         []string
+	ExcludeVendor   bool
+	UprobeWildcards []string
+	Args            []string
+	Threshold       time.Duration
+	Sched           bool
+	Stat            bool
+	StatInterval    time.Duration
+}
+
 type Tracer struct {
 	bin             string
+	pid             int
+	command         []string
 	elf             *elf.ELF
+	runtime         elf.Runtime
 	excludeVendor   bool
 	uprobeWildcards []string
 	args            []string
+	threshold       time.Duration
+	sched           bool
+	stat            bool
+	statInterval    time.Duration
 
-	bpf *bpf.BPF
+	bpf      *bpf.BPF
+	exitCode int
 }
 
-func NewTracer(bin string, excludeVendor bool, uprobeWildcards, args []string) (_ *Tracer, err error) {
-	elf, err := elf.New(bin)
+func NewTracer(opts TracerOptions) (_ *Tracer, err error) {
+	bin := opts.Bin
+	switch {
+	case opts.Pid != 0:
+		if bin, err = proc.ExePath(opts.Pid); err != nil {
+			return
+		}
+		log.Debugf("found executable of pid %d: %s", opts.Pid, bin)
+	case len(opts.Command) > 0:
+		if bin, err = exec.LookPath(opts.Command[0]); err != nil {
+			return
+		}
+	}
+
+	elf, err := elf.New(bin, elf.Options{DebugDir: opts.DebugDir, GoVersion: opts.GoVersion})
+	if err != nil {
+		return
+	}
+	runtime, err := elf.Runtime()
 	if err != nil {
 		return
 	}
+	log.Debugf("%s is built by %s", bin, runtime.Version)
+	if debugBin := elf.DebugBin(); debugBin != "" && debugBin != bin {
+		log.Debugf("found debug info of %s: %s", bin, debugBin)
+	}
 
 	return &Tracer{
 		bin:             bin,
+		pid:             opts.Pid,
+		command:         opts.Command,
 		elf:             elf,
-		excludeVendor:   excludeVendor,
-		uprobeWildcards: uprobeWildcards,
-		args:            args,
+		runtime:         runtime,
+		excludeVendor:   opts.ExcludeVendor,
+		uprobeWildcards: opts.UprobeWildcards,
+		args:            opts.Args,
+		threshold:       opts.Threshold,
+		sched:           opts.Sched,
+		stat:            opts.Stat,
+		statInterval:    opts.StatInterval,
 
 		bpf: bpf.New(),
 	}, nil
 }
 
-func (t *Tracer) ParseArgs(inputs []string) (in []string, fetch map[string]map[string]string, err error) {
-	fetch = map[string]map[string]string{}
+// ParseArgs splits the wildcards from the statements to fetch at the entry
+// in the trailing parentheses, and at the RET after "->", such as
+// main.parse(s) -> (n, err).
+func (t *Tracer) ParseArgs(inputs []string) (in []string, fetch, retFetch map[string][]uprobe.FetchStatement, err error) {
+	fetch, retFetch = map[string][]uprobe.FetchStatement{}, map[string][]uprobe.FetchStatement{}
 	for _, input := range inputs {
-		if input[len(input)-1] == ')' {
-			stack := []byte{')'}
-			for i := len(input) - 2; i >= 0; i-- {
-				if input[i] == ')' {
-					stack = append(stack, ')')
-				} else if input[i] == '(' {
-					if len(stack) > 0 && stack[len(stack)-1] == ')' {
-						stack = stack[:len(stack)-1]
-					} else {
-						err = fmt.Errorf("imbalanced parenthese: %s", input)
-						return
-					}
-				}
-
-				if len(stack) == 0 {
-					funcname := input[:i]
-					fetch[funcname] = map[string]string{}
-					for _, part := range strings.Split(input[i+1:len(input)-1], ",") {
-						varState := strings.Split(part, "=")
-						if len(varState) != 2 {
-							err = fmt.Errorf("invalid variable statement: %s", varState)
-							return
-						}
-						fetch[funcname][strings.TrimSpace(varState[0])] = strings.TrimSpace(varState[1])
-					}
-					input = input[:i]
-					break
-				}
+		input, results, hasResults := strings.Cut(input, "->")
+		funcname, statements, err := t.parseStatements(strings.TrimSpace(input))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if statements != nil {
+			fetch[funcname] = statements
+		}
+		if hasResults {
+			results = strings.TrimSpace(results)
+			rest, statements, err := t.parseStatements(results)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if rest != "" || statements == nil {
+				return nil, nil, nil, fmt.Errorf("results must be in parentheses: %s", results)
 			}
-			if len(stack) > 0 {
+			retFetch[funcname] = statements
+		}
+		in = append(in, funcname)
+	}
+	return
+}
+
+// parseStatements parses the statements in the trailing parentheses of the
+// input, which are prefixed by the wildcard.
+func (t *Tracer) parseStatements(input string) (wildcard string, statements []uprobe.FetchStatement, err error) {
+	if input == "" || input[len(input)-1] != ')' {
+		return input, nil, nil
+	}
+	stack := []byte{')'}
+	for i := len(input) - 2; i >= 0; i-- {
+		if input[i] == ')' {
+			stack = append(stack, ')')
+		} else if input[i] == '(' {
+			if len(stack) > 0 && stack[len(stack)-1] == ')' {
+				stack = stack[:len(stack)-1]
+			} else {
 				err = fmt.Errorf("imbalanced parenthese: %s", input)
 				return
 			}
 		}
 
-		in = append(in, input)
+		if len(stack) == 0 {
+			statements = []uprobe.FetchStatement{}
+			for _, part := range strings.Split(input[i+1:len(input)-1], ",") {
+				if strings.TrimSpace(part) == "" {
+					continue
+				}
+				varState := strings.Split(part, "=")
+				if len(varState) == 1 {
+					// Go expression named by itself
+					varState = append(varState, varState[0])
+				}
+				if len(varState) != 2 {
+					err = fmt.Errorf("invalid variable statement: %s", varState)
+					return
+				}
+				statements = append(statements, uprobe.FetchStatement{
+					Varname:   strings.TrimSpace(varState[0]),
+					Statement: strings.TrimSpace(varState[1]),
+				})
+			}
+			return input[:i], statements, nil
+		}
 	}
+	err = fmt.Errorf("imbalanced parenthese: %s", input)
 	return
 }
 
 func (t *Tracer) Start() (err error) {
-	in, fetch, err := t.ParseArgs(t.args)
+	in, fetch, retFetch, err := t.ParseArgs(t.args)
 	if err != nil {
 		return
 	}
@@ -105,13 +193,21 @@ func (t *Tracer) Start() (err error) {
 		UprobeWildcards: t.uprobeWildcards,
 		OutputWildcards: in,
 		Fetch:           fetch,
+		RetFetch:        retFetch,
+		Stat:            t.stat,
+		Sched:           t.sched,
 	})
 	if err != nil {
 		return
 	}
 
+	prompt := fmt.Sprintf("found %d uprobes", len(uprobes))
+	if len(uprobes) > 1000 {
+		// uprobe_multi attaches them at once since linux 6.6
+		prompt += ", which take long to attach and detach one by one on kernels without uprobe_multi"
+	}
 requireConfirm:
-	fmt.Fprintf(os.Stdout, "found %d uprobes, large number of uprobes (>1000) need long time for attaching and detaching, continue? [Y/n]\n", len(uprobes))
+	fmt.Fprintf(os.Stdout, "%s, continue? [Y/n]\n", prompt)
 	input, err := bufio.NewReader(os.Stdin).ReadString('\n')
 	if err != nil {
 		return errors.WithStack(err)
@@ -125,6 +221,20 @@ requireConfirm:
 		goto requireConfirm
 	}
 
+	var process *proc.Process
+	if len(t.command) > 0 {
+		if process, err = proc.Launch(t.command); err != nil {
+			return
+		}
+		t.pid = process.Pid
+		log.Debugf("launched %v as pid %d", t.command, t.pid)
+		defer func() {
+			if err != nil {
+				process.Kill()
+			}
+		}()
+	}
+
 	goidOffset, err := t.elf.FindGoidOffset()
 	if err != nil {
 		return
@@ -134,9 +244,29 @@ requireConfirm:
 		return
 	}
 	log.Debugf("offset of goid from g is %d, offset of g from fs is -0x%x\n", goidOffset, -gOffset)
+	callergp, callerpc, err := t.elf.NewprocRegisters()
+	if err != nil {
+		return
+	}
+	if err = t.elf.SaveCache(); err != nil {
+		log.Debugf("failed to save cache of %s: %+v", t.bin, err)
+	}
+	loadBias, err := t.loadBias()
+	if err != nil {
+		return
+	}
 	if err = t.bpf.Load(uprobes, bpf.LoadOptions{
 		GoidOffset: goidOffset,
 		GOffset:    gOffset,
+		Pid:        t.pid,
+		Stat:       t.stat,
+		RegABI:     t.runtime.RegABI,
+		PIE:        t.elf.PIE(),
+		LoadBias:   loadBias,
+
+		NewprocCallerGp: uint8(callergp),
+		NewprocCallerPc: uint8(callerpc),
+		Threshold:       t.threshold,
 	}); err != nil {
 		return
 	}
@@ -150,15 +280,124 @@ requireConfirm:
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	eventManager, err := eventmanager.New(uprobes, t.elf, t.bpf.PollArg(ctx))
+	var (
+		eventCh      <-chan bpf.GofuncgraphEvent
+		eventManager *eventmanager.EventManager
+	)
+	if !t.stat {
+		if eventCh, err = t.bpf.PollEvents(ctx); err != nil {
+			return
+		}
+		if eventManager, err = eventmanager.New(uprobes, t.elf, eventmanager.Options{
+			Threshold: t.threshold,
+			Sched:     t.sched,
+		}); err != nil {
+			return
+		}
+	}
+
+	if process != nil {
+		if err = process.Resume(); err != nil {
+			return
+		}
+		go func() {
+			defer stop()
+			code, err := process.Wait()
+			if err != nil {
+				log.Errorf("failed to wait for pid %d: %+v", process.Pid, err)
+				return
+			}
+			t.exitCode = code
+		}()
+	}
+
+	if t.stat {
+		return t.printStats(ctx)
+	}
+
+	go t.reportLostEvents(ctx)
+
+	for event := range eventCh {
+		if err = eventManager.Handle(event); err != nil {
+			return
+		}
+	}
+	if err = eventManager.PrintRemaining(); err != nil {
+		return
+	}
+	lost, err := t.bpf.LostEvents()
 	if err != nil {
 		return
 	}
+	eventManager.PrintLostEvents(lost)
+	return
+}
 
-	for event := range t.bpf.PollEvents(ctx) {
-		if err = eventManager.Handle(event); err != nil {
+// loadBias returns the load bias of the PIE binary in the traced process.
+func (t *Tracer) loadBias() (bias uint64, err error) {
+	if !t.elf.PIE() || t.pid == 0 {
+		return
+	}
+	start, offset, err := proc.ExeMapping(t.pid)
+	if err != nil {
+		return
+	}
+	if bias, err = t.elf.LoadBias(start, offset); err != nil {
+		return
+	}
+	log.Debugf("load bias of pid %d is 0x%x", t.pid, bias)
+	return
+}
+
+func (t *Tracer) printStats(ctx context.Context) (err error) {
+	printer := stat.New(t.elf)
+	ticker := time.NewTicker(t.statInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		stats, err := t.bpf.FuncStats()
+		if err != nil {
+			return err
+		}
+		printer.Print(stats)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (t *Tracer) reportLostEvents(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	reported := uint64(0)
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+		}
+		lost, err := t.bpf.LostEvents()
+		if err != nil {
+			log.Debugf("failed to get lost events: %+v", err)
+			continue
+		}
+		total := uint64(0)
+		for _, counts := range lost {
+			for _, count := range counts {
+				total += count
+			}
+		}
+		if total > reported {
+			log.Warnf("%d events lost in the last second, %d in total", total-reported, total)
+			reported = total
 		}
 	}
-	return eventManager.PrintRemaining()
+}
+
+// ExitCode returns the exit code of the launched command.
+func (t *Tracer) ExitCode() int {
+	return t.exitCode
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jschwinger233/gofuncgraph/internal/uprobe"
+)
+
+func TestParseArgs(t *testing.T) {
+	type fetches = map[string][]uprobe.FetchStatement
+	for _, tt := range []struct {
+		input           string
+		in              []string
+		fetch, retFetch fetches
+		err             bool
+	}{
+		{
+			input: "main.handleBar",
+			in:    []string{"main.handleBar"},
+			fetch: fetches{}, retFetch: fetches{},
+		},
+		{
+			input: "main.handleBar(req=r.URL.Path, w)",
+			in:    []string{"main.handleBar"},
+			fetch: fetches{"main.handleBar": {
+				{Varname: "req", Statement: "r.URL.Path"},
+				{Varname: "w", Statement: "w"},
+			}},
+			retFetch: fetches{},
+		},
+		{
+			input: "fmt.Fprintf(format) -> (n, err)",
+			in:    []string{"fmt.Fprintf"},
+			fetch: fetches{"fmt.Fprintf": {{Varname: "format", Statement: "format"}}},
+			retFetch: fetches{"fmt.Fprintf": {
+				{Varname: "n", Statement: "n"},
+				{Varname: "err", Statement: "err"},
+			}},
+		},
+		{
+			input: "fmt.Fprintf->(n=%ax:s64,err=iface)",
+			in:    []string{"fmt.Fprintf"},
+			fetch: fetches{},
+			retFetch: fetches{"fmt.Fprintf": {
+				{Varname: "n", Statement: "%ax:s64"},
+				{Varname: "err", Statement: "iface"},
+			}},
+		},
+		{
+			// parentheses nested in the wildcard and the statements
+			input: "net/http.(*conn).serve(c=(*c).remoteAddr) -> (msg=err.(*errors.errorString).s)",
+			in:    []string{"net/http.(*conn).serve"},
+			fetch: fetches{"net/http.(*conn).serve": {{Varname: "c", Statement: "(*c).remoteAddr"}}},
+			retFetch: fetches{"net/http.(*conn).serve": {
+				{Varname: "msg", Statement: "err.(*errors.errorString).s"},
+			}},
+		},
+		{
+			input: "net/http.(*conn).serve -> (err)",
+			in:    []string{"net/http.(*conn).serve"},
+			fetch: fetches{},
+			retFetch: fetches{"net/http.(*conn).serve": {
+				{Varname: "err", Statement: "err"},
+			}},
+		},
+		{
+			input:    "main.handleBar() -> ()",
+			in:       []string{"main.handleBar"},
+			fetch:    fetches{"main.handleBar": {}},
+			retFetch: fetches{"main.handleBar": {}},
+		},
+		{input: "fmt.Fprintf -> n, err", err: true},
+		{input: "fmt.Fprintf -> n, err)", err: true},
+		{input: "fmt.Fprintf -> (n, err", err: true},
+		{input: "fmt.Fprintf ->", err: true},
+		{input: "fmt.Fprintf -> (n) (err)", err: true},
+		{input: "fmt.Fprintf -> ((n)", err: true},
+		{input: "fmt.Fprintf(format))", err: true},
+		{input: "fmt.Fprintf(a=b=c)", err: true},
+	} {
+		in, fetch, retFetch, err := (&Tracer{}).ParseArgs([]string{tt.input})
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseArgs(%q): got no error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseArgs(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(in, tt.in) || !reflect.DeepEqual(fetch, tt.fetch) || !reflect.DeepEqual(retFetch, tt.retFetch) {
+			t.Errorf("ParseArgs(%q): got %q, %+v, %+v, want %q, %+v, %+v", tt.input, in, fetch, retFetch, tt.in, tt.fetch, tt.retFetch)
+		}
+	}
+}